@@ -0,0 +1,39 @@
+package dmc
+
+import (
+	"net"
+)
+
+// Credential holds the access details used by a driver, blank values
+// fall back to the driver settings or environment variables.
+type Credential struct {
+	Username  string
+	Password  string
+	Community string
+	Security  Security
+}
+
+// Credentials, if set, is used by the drivers to find the access details
+// for a model name (e.g. "MikroTik" or "Trimble NetR9") at a given address.
+var Credentials func(model string, ip net.IP) *Credential
+
+func credential(model string, ip net.IP) Credential {
+	if Credentials != nil {
+		if c := Credentials(model, ip); c != nil {
+			return *c
+		}
+	}
+	return Credential{}
+}
+
+// merge overrides the driver security settings with any given credentials
+func (s Security) merge(c Security) Security {
+	return Security{
+		Username:       def(c.Username, s.Username),
+		AuthProtocol:   def(c.AuthProtocol, s.AuthProtocol),
+		AuthPassphrase: def(c.AuthPassphrase, s.AuthPassphrase),
+		PrivProtocol:   def(c.PrivProtocol, s.PrivProtocol),
+		PrivPassphrase: def(c.PrivPassphrase, s.PrivPassphrase),
+		Fallback:       def(c.Fallback, s.Fallback),
+	}
+}
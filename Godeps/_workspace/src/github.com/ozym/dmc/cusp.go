@@ -1,6 +1,7 @@
 package dmc
 
 import (
+	"context"
 	//"fmt"
 
 	"crypto/tls"
@@ -42,17 +43,29 @@ func (c *Cusp) Group(g ModelType) bool {
 	}
 }
 
+func (c *Cusp) credentials(ip net.IP) (string, string) {
+	x := credential(c.Name(), ip)
+
+	username := env(def(x.Username, c.Username), "CUSP_USERNAME", "default")
+	password := env(def(x.Password, c.Password), "CUSP_PASSWORD", "default")
+
+	return username, password
+}
+
 func (c *Cusp) Identify(hostname string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return c.IdentifyContext(context.Background(), hostname, ip, timeout, retries)
+}
+
+func (c *Cusp) IdentifyContext(ctx context.Context, hostname string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
 
-	username := env(c.Username, "CUSP_USERNAME", "default")
-	password := env(c.Password, "CUSP_PASSWORD", "default")
+	username, password := c.credentials(ip)
 
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
-	cli := &http.Client{Transport: tr}
+	cli := &http.Client{Transport: tr, Timeout: timeout}
 
-	request, err := http.NewRequest("GET", "https://"+ip.String()+"/admin/status.cgi", nil)
+	request, err := http.NewRequestWithContext(ctx, "GET", "https://"+ip.String()+"/admin/status.cgi", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -114,16 +127,19 @@ func (c *Cusp) Identify(hostname string, ip net.IP, timeout time.Duration, retri
 }
 
 func (c *Cusp) Status(hostname string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return c.StatusContext(context.Background(), hostname, ip, timeout, retries)
+}
+
+func (c *Cusp) StatusContext(ctx context.Context, hostname string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
 
-	username := env(c.Username, "CUSP_USERNAME", "default")
-	password := env(c.Password, "CUSP_PASSWORD", "default")
+	username, password := c.credentials(ip)
 
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
-	cli := &http.Client{Transport: tr}
+	cli := &http.Client{Transport: tr, Timeout: timeout}
 
-	request, err := http.NewRequest("GET", "https://"+ip.String()+"/admin/status.cgi", nil)
+	request, err := http.NewRequestWithContext(ctx, "GET", "https://"+ip.String()+"/admin/status.cgi", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,7 @@
 package dmc
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"time"
@@ -21,17 +22,104 @@ func (d *Device) Match(model Model) bool {
 }
 
 func (d *Device) Identify(model Model, orig string, timeout time.Duration, retries int) (*State, error) {
-	return model.Identify(orig, d.IP, timeout, retries)
+	return d.IdentifyContext(context.Background(), model, orig, timeout, retries)
+}
+
+// cancellable runs a request which can't itself be cancelled, giving up waiting on it when the context is done.
+func cancellable(ctx context.Context, fn func() (*State, error)) (*State, error) {
+	type result struct {
+		s   *State
+		err error
+	}
+
+	res := make(chan result, 1)
+	go func() {
+		s, err := fn()
+		res <- result{s, err}
+	}()
+
+	select {
+	case r := <-res:
+		return r.s, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// IdentifyContext is the cancellable form of Identify.
+func (d *Device) IdentifyContext(ctx context.Context, model Model, orig string, timeout time.Duration, retries int) (*State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var s *State
+	var err error
+
+	switch m := model.(type) {
+	case ContextModel:
+		s, err = m.IdentifyContext(ctx, orig, d.IP, timeout, retries)
+	default:
+		s, err = cancellable(ctx, func() (*State, error) {
+			return model.Identify(orig, d.IP, timeout, retries)
+		})
+	}
+
+	// report the cancellation rather than any resulting network errors
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return s, err
+}
+
+// Status returns the state of health of the device if the model supports it.
+func (d *Device) Status(model Model, orig string, timeout time.Duration, retries int) (*State, error) {
+	return d.StatusContext(context.Background(), model, orig, timeout, retries)
+}
+
+// StatusContext is the cancellable form of Status.
+func (d *Device) StatusContext(ctx context.Context, model Model, orig string, timeout time.Duration, retries int) (*State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var s *State
+	var err error
+
+	switch r := model.(type) {
+	case ContextStatusReporter:
+		s, err = r.StatusContext(ctx, orig, d.IP, timeout, retries)
+	case StatusReporter:
+		s, err = cancellable(ctx, func() (*State, error) {
+			return r.Status(orig, d.IP, timeout, retries)
+		})
+	default:
+		return nil, nil
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return s, err
 }
 
 func (d *Device) Discover(model Model, orig string, timeout time.Duration, retries int) *State {
+	return d.DiscoverContext(context.Background(), model, orig, timeout, retries)
+}
+
+// DiscoverContext is the cancellable form of Discover, it stops trying models once the context is done.
+func (d *Device) DiscoverContext(ctx context.Context, model Model, orig string, timeout time.Duration, retries int) *State {
 
 	for _, g := range model.Groups() {
 		for _, m := range ModelList {
+			if ctx.Err() != nil {
+				return nil
+			}
 			if !m.Group(g) {
 				continue
 			}
-			if s, _ := d.Identify(m, orig, timeout, retries); s != nil {
+			if s, _ := d.IdentifyContext(ctx, m, orig, timeout, retries); s != nil {
 				return s
 			}
 		}
@@ -0,0 +1,313 @@
+package dmc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ozym/qdp"
+	"github.com/soniah/gosnmp"
+)
+
+// Port probe results.
+const (
+	PortOpen     = "open"
+	PortClosed   = "closed"
+	PortFiltered = "filtered"
+)
+
+// Fingerprint summarises a quick probe of the services offered by a device.
+type Fingerprint struct {
+	SNMP     string // state of udp 161
+	ObjectID string // sysObjectID, if the default community was accepted
+	HTTP     string // state of tcp 80
+	HTTPS    string // state of tcp 443
+	Server   string // http Server header
+	Realm    string // http WWW-Authenticate header
+	QDP      []string
+}
+
+// The Candidate interface is optionally implemented by Models which can judge whether a
+// fingerprint could belong to them. A positive score moves the Model earlier in the list
+// of drivers to try, a negative score rules it out.
+type Candidate interface {
+	Candidate(f *Fingerprint) int
+}
+
+// Values gives the fingerprint in a form suitable for storing in a State.
+func (f *Fingerprint) Values() map[string]interface{} {
+	v := map[string]interface{}{
+		"snmp":  f.SNMP,
+		"http":  f.HTTP,
+		"https": f.HTTPS,
+	}
+	if f.ObjectID != "" {
+		v["sysobjectid"] = f.ObjectID
+	}
+	if f.Server != "" {
+		v["server"] = f.Server
+	}
+	if f.Realm != "" {
+		v["realm"] = f.Realm
+	}
+	if len(f.QDP) > 0 {
+		v["qdp"] = strings.Join(f.QDP, ",")
+	}
+	return v
+}
+
+// Banner is the combined http Server and WWW-Authenticate headers.
+func (f *Fingerprint) Banner() string {
+	return strings.TrimSpace(f.Server + " " + f.Realm)
+}
+
+func portState(err error) string {
+	switch {
+	case err == nil:
+		return PortOpen
+	case strings.Contains(err.Error(), "refused"):
+		return PortClosed
+	default:
+		return PortFiltered
+	}
+}
+
+// probeSNMP uses the same security settings as the drivers, the probe is skipped if
+// no v3 user is configured and community access has not been allowed.
+func probeSNMP(ctx context.Context, f *Fingerprint, ip net.IP, timeout time.Duration) {
+	c := credential("", ip)
+
+	community := env(c.Community, "SNMP_COMMUNITY", "public")
+
+	snmp, err := session(ctx, ip, community, gosnmp.Version1, Security{}.merge(c.Security).resolve("PROBE"), timeout, 0)
+	if err != nil {
+		return
+	}
+	defer snmp.Conn.Close()
+	defer watch(ctx, snmp)()
+
+	oid, err := sysObjectID(snmp)
+	f.SNMP = portState(err)
+	if oid != nil {
+		f.ObjectID = *oid
+	}
+}
+
+func probeTCP(ctx context.Context, ip net.IP, port string, timeout time.Duration) string {
+	d := net.Dialer{Timeout: timeout}
+
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		return portState(err)
+	}
+	conn.Close()
+
+	return PortOpen
+}
+
+func probeHTTP(ctx context.Context, f *Fingerprint, ip net.IP, timeout time.Duration, mu *sync.Mutex) {
+	cli := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for _, u := range []string{"http://" + ip.String() + "/", "https://" + ip.String() + "/"} {
+		resp, err := get(ctx, cli, u)
+		if resp == nil || err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		mu.Lock()
+		f.Server = resp.Header.Get("Server")
+		f.Realm = resp.Header.Get("WWW-Authenticate")
+		mu.Unlock()
+
+		return
+	}
+}
+
+// Probe fingerprints a device by checking snmp, web and qdp services in parallel.
+func Probe(ctx context.Context, ip net.IP, timeout time.Duration) *Fingerprint {
+	var f Fingerprint
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+
+	run(func() {
+		var x Fingerprint
+		probeSNMP(ctx, &x, ip, timeout)
+		mu.Lock()
+		f.SNMP, f.ObjectID = x.SNMP, x.ObjectID
+		mu.Unlock()
+	})
+	run(func() {
+		s := probeTCP(ctx, ip, "80", timeout)
+		mu.Lock()
+		f.HTTP = s
+		mu.Unlock()
+	})
+	run(func() {
+		s := probeTCP(ctx, ip, "443", timeout)
+		mu.Lock()
+		f.HTTPS = s
+		mu.Unlock()
+	})
+	run(func() {
+		probeHTTP(ctx, &f, ip, timeout, &mu)
+	})
+	for _, p := range []string{"5330", "6330"} {
+		p := p
+		run(func() {
+			if s, err := qdp.ReadSerialContext(ctx, ip.String(), p, timeout); s != nil && err == nil {
+				mu.Lock()
+				f.QDP = append(f.QDP, p)
+				mu.Unlock()
+			}
+		})
+	}
+
+	wg.Wait()
+
+	sort.Strings(f.QDP)
+
+	return &f
+}
+
+// Order narrows and sorts the given models based on how well they match the fingerprint,
+// models which don't implement the Candidate interface are kept after any likely matches.
+func (f *Fingerprint) Order(models []Model) []Model {
+	type scored struct {
+		model Model
+		score int
+	}
+
+	var list []scored
+	for _, m := range models {
+		var n int
+		if c, ok := m.(Candidate); ok {
+			n = c.Candidate(f)
+		}
+		if n < 0 {
+			continue
+		}
+		list = append(list, scored{m, n})
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].score > list[j].score
+	})
+
+	var res []Model
+	for _, x := range list {
+		res = append(res, x.model)
+	}
+
+	return res
+}
+
+// snmpCandidate scores snmp based models against their expected sysObjectID prefixes.
+func snmpCandidate(f *Fingerprint, objects ...string) int {
+	switch {
+	case f.SNMP == PortClosed:
+		return -1
+	case f.ObjectID == "":
+		return 0
+	}
+	for _, o := range objects {
+		if strings.HasPrefix(f.ObjectID, o) {
+			return 10
+		}
+	}
+	// another agent answered the default community
+	return -1
+}
+
+// webCandidate scores web managed models on the state of their port, optionally checking the http banner.
+func webCandidate(f *Fingerprint, state string, banner string) int {
+	switch {
+	case state == PortClosed:
+		return -1
+	case banner != "" && strings.Contains(strings.ToLower(f.Banner()), strings.ToLower(banner)):
+		return 5
+	default:
+		return 0
+	}
+}
+
+func (m *MikroTik) Candidate(f *Fingerprint) int {
+	return snmpCandidate(f, ".1.3.6.1.4.1.14988.1")
+}
+
+func (m *Ubiquiti) Candidate(f *Fingerprint) int {
+	return snmpCandidate(f, ".1.3.6.1.4.1.10002.1")
+}
+
+func (f *Freewave) Candidate(x *Fingerprint) int {
+	return snmpCandidate(x, ".1.3.6.1.4.1.29956.2.1.1")
+}
+
+func (m *SNMPModel) Candidate(f *Fingerprint) int {
+	return snmpCandidate(f, m.Objects...)
+}
+
+func (q *Quanterra) Candidate(f *Fingerprint) int {
+	switch {
+	case len(f.QDP) > 0:
+		return 10
+	case f.ObjectID != "":
+		// an snmp agent answered, which a datalogger doesn't run
+		return -1
+	default:
+		// a lost qdp probe says nothing about the device
+		return 0
+	}
+}
+
+func (h *Hongdian) Candidate(f *Fingerprint) int {
+	return webCandidate(f, f.HTTP, "")
+}
+
+func (v *ViPR) Candidate(f *Fingerprint) int {
+	return webCandidate(f, f.HTTP, "")
+}
+
+func (r *Rock) Candidate(f *Fingerprint) int {
+	return webCandidate(f, f.HTTP, "")
+}
+
+func (t *Trimble) Candidate(f *Fingerprint) int {
+	return webCandidate(f, f.HTTP, "")
+}
+
+func (c *Cusp) Candidate(f *Fingerprint) int {
+	return webCandidate(f, f.HTTPS, "")
+}
+
+func (m *HTTPModel) Candidate(f *Fingerprint) int {
+	switch {
+	case m.Port > 0:
+		// non standard ports aren't probed
+		return webCandidate(f, "", m.Banner)
+	case m.Scheme == "https":
+		return webCandidate(f, f.HTTPS, m.Banner)
+	default:
+		return webCandidate(f, f.HTTP, m.Banner)
+	}
+}
@@ -1,6 +1,7 @@
 package dmc
 
 import (
+	"context"
 	"net"
 	"regexp"
 	"strconv"
@@ -12,6 +13,7 @@ import (
 
 type Freewave struct {
 	Community string
+	Security  Security
 }
 
 func (f *Freewave) Name() string {
@@ -35,22 +37,27 @@ func (f *Freewave) Group(g ModelType) bool {
 	}
 }
 
+func (f *Freewave) connect(ctx context.Context, ip net.IP, timeout time.Duration, retries int) (*gosnmp.GoSNMP, error) {
+
+	c := credential(f.Name(), ip)
+
+	community := env(def(c.Community, f.Community), "FREEWAVE_COMMUNITY", "public")
+
+	return session(ctx, ip, community, gosnmp.Version1, f.Security.merge(c.Security).resolve("FREEWAVE"), timeout, retries)
+}
+
 func (f *Freewave) Identify(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return f.IdentifyContext(context.Background(), orig, ip, timeout, retries)
+}
 
-	community := env(f.Community, "FREEWAVE_COMMUNITY", "public")
+func (f *Freewave) IdentifyContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
 
-	var snmp = &gosnmp.GoSNMP{
-		Target:    ip.String(),
-		Port:      161,
-		Community: community,
-		Version:   gosnmp.Version1,
-		Timeout:   timeout,
-		Retries:   retries,
-	}
-	if err := snmp.Connect(); err != nil {
+	snmp, err := f.connect(ctx, ip, timeout, retries)
+	if err != nil {
 		return nil, nil
 	}
 	defer snmp.Conn.Close()
+	defer watch(ctx, snmp)()
 
 	oid, err := sysObjectID(snmp)
 	if oid == nil || err != nil {
@@ -115,3 +122,19 @@ func (f *Freewave) Identify(orig string, ip net.IP, timeout time.Duration, retri
 	// done ...
 	return &s, nil
 }
+
+func (f *Freewave) Status(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return f.StatusContext(context.Background(), orig, ip, timeout, retries)
+}
+
+func (f *Freewave) StatusContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+
+	snmp, err := f.connect(ctx, ip, timeout, retries)
+	if err != nil {
+		return nil, err
+	}
+	defer snmp.Conn.Close()
+	defer watch(ctx, snmp)()
+
+	return status(snmp)
+}
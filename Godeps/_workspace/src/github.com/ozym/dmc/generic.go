@@ -0,0 +1,356 @@
+package dmc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soniah/gosnmp"
+	"gopkg.in/yaml.v2"
+)
+
+// SNMPValue maps an snmp object into a state value.
+type SNMPValue struct {
+	OID   string  `yaml:"oid"`
+	Key   string  `yaml:"key"`
+	Type  string  `yaml:"type"` // string, int, float, hex, or fields
+	Scale float64 `yaml:"scale"`
+	Field int     `yaml:"field"` // used with the fields type
+}
+
+// SNMPLabel decides the model label, either from a single object or any value in a walk.
+type SNMPLabel struct {
+	OID   string `yaml:"oid"`
+	Walk  string `yaml:"walk"`
+	Match string `yaml:"match"`
+	Label string `yaml:"label"`
+
+	match *regexp.Regexp
+}
+
+// SNMPTable walks a set of table columns into a list of rows.
+type SNMPTable struct {
+	Key     string      `yaml:"key"`
+	Columns []SNMPValue `yaml:"columns"`
+}
+
+// SNMPModel is a generic snmp driver built from a yaml definition.
+type SNMPModel struct {
+	Label     string      `yaml:"name"`
+	Pattern   string      `yaml:"match"`
+	Members   []string    `yaml:"groups"`
+	Version   string      `yaml:"version"`
+	Env       string      `yaml:"env"`
+	Community string      `yaml:"community"`
+	Security  Security    `yaml:"security"`
+	Objects   []string    `yaml:"objects"`
+	Default   string      `yaml:"default"`
+	Labels    []SNMPLabel `yaml:"labels"`
+	Values    []SNMPValue `yaml:"values"`
+	Tables    []SNMPTable `yaml:"tables"`
+
+	pattern *regexp.Regexp
+	groups  []ModelType
+}
+
+var groupNames = map[string]ModelType{
+	"radio":      RadioModel,
+	"cellular":   CellularModel,
+	"router":     RouterModel,
+	"datalogger": DataloggerModel,
+	"strong":     StrongModel,
+	"gnss":       GNSSModel,
+}
+
+func (m *SNMPModel) compile() error {
+	var err error
+
+	if m.Label == "" {
+		return fmt.Errorf("snmp model is missing a name")
+	}
+	if !(len(m.Objects) > 0) {
+		return fmt.Errorf("snmp model %s has no sysObjectID prefixes", m.Label)
+	}
+	if m.Pattern == "" {
+		m.Pattern = "^" + regexp.QuoteMeta(m.Label)
+	}
+	if m.pattern, err = regexp.Compile(m.Pattern); err != nil {
+		return err
+	}
+	for _, g := range m.Members {
+		t, ok := groupNames[strings.ToLower(g)]
+		if !ok {
+			return fmt.Errorf("snmp model %s has an unknown group: %s", m.Label, g)
+		}
+		m.groups = append(m.groups, t)
+	}
+	for i := range m.Labels {
+		if m.Labels[i].match, err = regexp.Compile(m.Labels[i].Match); err != nil {
+			return err
+		}
+	}
+	if m.Env == "" {
+		m.Env = strings.ToUpper(regexp.MustCompile("[^A-Za-z0-9]+").ReplaceAllString(m.Label, "_"))
+	}
+
+	return nil
+}
+
+// LoadSNMPModels decodes a yaml list of snmp model definitions.
+func LoadSNMPModels(data []byte) ([]Model, error) {
+	var defs []*SNMPModel
+	if err := yaml.Unmarshal(data, &defs); err != nil {
+		return nil, err
+	}
+
+	var models []Model
+	for _, d := range defs {
+		if err := d.compile(); err != nil {
+			return nil, err
+		}
+		models = append(models, d)
+	}
+
+	return models, nil
+}
+
+// Register adds extra models to the ModelList.
+func Register(models ...Model) {
+	ModelList = append(ModelList, models...)
+}
+
+func (m *SNMPModel) Name() string {
+	return m.Label
+}
+
+func (m *SNMPModel) MatchString(s string) bool {
+	return m.pattern.MatchString(s)
+}
+
+func (m *SNMPModel) Groups() []ModelType {
+	return m.groups
+}
+
+func (m *SNMPModel) Group(g ModelType) bool {
+	for _, t := range m.groups {
+		if t == g {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *SNMPModel) connect(ctx context.Context, ip net.IP, timeout time.Duration, retries int) (*gosnmp.GoSNMP, error) {
+
+	c := credential(m.Name(), ip)
+
+	community := env(def(c.Community, m.Community), m.Env+"_COMMUNITY", "public")
+
+	version := gosnmp.Version2c
+	if m.Version == "1" {
+		version = gosnmp.Version1
+	}
+
+	return session(ctx, ip, community, version, m.Security.merge(c.Security).resolve(m.Env), timeout, retries)
+}
+
+// convert an snmp value into the requested state value type
+func (v SNMPValue) convert(pdu gosnmp.SnmpPDU) (interface{}, bool) {
+
+	var text string
+	var number float64
+	var numeric bool
+
+	switch x := pdu.Value.(type) {
+	case []byte:
+		text = strings.TrimRight(string(x), "\u0000")
+		if strings.ToLower(v.Type) == "hex" {
+			var parts []string
+			for _, b := range x {
+				parts = append(parts, fmt.Sprintf("%02x", b))
+			}
+			return strings.Join(parts, ":"), len(parts) > 0
+		}
+	case string:
+		text = x
+	case int:
+		number, numeric = float64(x), true
+	case uint:
+		number, numeric = float64(x), true
+	case int64:
+		number, numeric = float64(x), true
+	case uint64:
+		number, numeric = float64(x), true
+	default:
+		return nil, false
+	}
+
+	if !numeric {
+		if f := strings.Fields(text); len(f) > 0 {
+			if n, err := strconv.ParseFloat(f[0], 64); err == nil {
+				number, numeric = n, true
+			}
+		}
+	}
+	if numeric && v.Scale != 0 {
+		number = number * v.Scale
+	}
+
+	switch strings.ToLower(v.Type) {
+	case "int", "integer":
+		if !numeric {
+			return nil, false
+		}
+		return int64(math.Floor(number + 0.5)), true
+	case "float", "number":
+		if !numeric {
+			return nil, false
+		}
+		return number, true
+	case "fields":
+		f := strings.Fields(text)
+		if v.Field < len(f) {
+			return f[v.Field], true
+		}
+		return nil, false
+	default:
+		if text == "" && numeric {
+			return strconv.FormatFloat(number, 'f', -1, 64), true
+		}
+		text = strings.TrimSpace(text)
+		return text, text != ""
+	}
+}
+
+func (m *SNMPModel) label(snmp *gosnmp.GoSNMP) string {
+	for _, l := range m.Labels {
+		var values []string
+		switch {
+		case l.OID != "":
+			r, err := snmp.Get([]string{l.OID})
+			if r == nil || err != nil {
+				continue
+			}
+			for _, p := range r.Variables {
+				if x, ok := (SNMPValue{}).convert(p); ok {
+					values = append(values, fmt.Sprint(x))
+				}
+			}
+		case l.Walk != "":
+			w, err := snmp.WalkAll(l.Walk)
+			if err != nil {
+				continue
+			}
+			for _, p := range w {
+				if x, ok := (SNMPValue{}).convert(p); ok {
+					values = append(values, fmt.Sprint(x))
+				}
+			}
+		}
+		for _, x := range values {
+			if i := l.match.FindStringSubmatchIndex(x); i != nil {
+				return string(l.match.ExpandString(nil, l.Label, x, i))
+			}
+		}
+	}
+
+	return def(m.Default, m.Label)
+}
+
+func (m *SNMPModel) Identify(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return m.IdentifyContext(context.Background(), orig, ip, timeout, retries)
+}
+
+func (m *SNMPModel) IdentifyContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+
+	snmp, err := m.connect(ctx, ip, timeout, retries)
+	if err != nil {
+		return nil, err
+	}
+	defer snmp.Conn.Close()
+	defer watch(ctx, snmp)()
+
+	oid, err := sysObjectID(snmp)
+	if oid == nil || err != nil {
+		return nil, err
+	}
+
+	var found bool
+	for _, o := range m.Objects {
+		if strings.HasPrefix(*oid, o) {
+			found = true
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	s := State{Values: make(map[string]interface{})}
+
+	s.Values["model"] = m.label(snmp)
+
+	// scalar values, requested in small batches
+	for i := 0; i < len(m.Values); i += 16 {
+		batch := m.Values[i:int(math.Min(float64(i+16), float64(len(m.Values))))]
+
+		var oids []string
+		for _, v := range batch {
+			oids = append(oids, v.OID)
+		}
+
+		r, err := snmp.Get(oids)
+		if r == nil || err != nil {
+			continue
+		}
+		for _, p := range r.Variables {
+			for _, v := range batch {
+				if strings.TrimPrefix(p.Name, ".") != strings.TrimPrefix(v.OID, ".") {
+					continue
+				}
+				if x, ok := v.convert(p); ok {
+					s.Values[v.Key] = x
+				}
+			}
+		}
+	}
+
+	// table walks, one row per index
+	for _, t := range m.Tables {
+		rows := make(map[string]map[string]interface{})
+
+		var order []string
+		for _, c := range t.Columns {
+			w, err := snmp.WalkAll(c.OID)
+			if err != nil {
+				continue
+			}
+			for _, p := range w {
+				idx := strings.TrimPrefix(strings.TrimPrefix(p.Name, "."), strings.TrimPrefix(c.OID, ".")+".")
+				x, ok := c.convert(p)
+				if !ok {
+					continue
+				}
+				if _, ok := rows[idx]; !ok {
+					rows[idx] = map[string]interface{}{"index": idx}
+					order = append(order, idx)
+				}
+				rows[idx][c.Key] = x
+			}
+		}
+
+		if len(order) > 0 {
+			var list []interface{}
+			for _, idx := range order {
+				list = append(list, rows[idx])
+			}
+			s.Values[t.Key] = list
+		}
+	}
+
+	return &s, nil
+}
@@ -1,6 +1,7 @@
 package dmc
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"regexp"
@@ -37,16 +38,25 @@ func (h *Hongdian) MatchString(s string) bool {
 }
 
 func (h *Hongdian) Identify(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return h.IdentifyContext(context.Background(), orig, ip, timeout, retries)
+}
+
+func (h *Hongdian) IdentifyContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
 
 	s := State{Values: make(map[string]interface{})}
 
 	// default model
 	s.Values["model"] = "Hongdian Cellular Modem"
 
-	cli := &http.Client{}
+	c := credential(h.Name(), ip)
+
+	username := env(def(c.Username, h.Username), "HONGDIAN_USERNAME", "admin")
+	password := env(def(c.Password, h.Password), "HONGDIAN_PASSWORD", "admin")
+
+	cli := &http.Client{Timeout: timeout}
 	pages := []string{"status_main.cgi", "lan_setup.cgi"}
 	for _, p := range pages {
-		r, err := h.discover(cli, "http://"+ip.String()+"/"+p)
+		r, err := h.discover(ctx, cli, username, password, "http://"+ip.String()+"/"+p)
 		if err != nil {
 			return nil, err
 		}
@@ -85,11 +95,9 @@ func (h *Hongdian) Identify(orig string, ip net.IP, timeout time.Duration, retri
 	return &s, nil
 }
 
-func (h *Hongdian) discover(cli *http.Client, url string) (map[string]string, error) {
-	username := env(h.Username, "HONGDIAN_USERNAME", "admin")
-	password := env(h.Password, "HONGDIAN_PASSWORD", "admin")
+func (h *Hongdian) discover(ctx context.Context, cli *http.Client, username, password, url string) (map[string]string, error) {
 
-	request, err := http.NewRequest("GET", url, nil)
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,7 @@
 package dmc
 
 import (
+	"context"
 	"net"
 	"regexp"
 	"strings"
@@ -11,6 +12,7 @@ import (
 
 type MikroTik struct {
 	Community string
+	Security  Security
 }
 
 func (m *MikroTik) Name() string {
@@ -36,22 +38,27 @@ func (m *MikroTik) Group(g ModelType) bool {
 	}
 }
 
+func (m *MikroTik) connect(ctx context.Context, ip net.IP, timeout time.Duration, retries int) (*gosnmp.GoSNMP, error) {
+
+	c := credential(m.Name(), ip)
+
+	community := env(def(c.Community, m.Community), "MIKROTIK_COMMUNITY", "public")
+
+	return session(ctx, ip, community, gosnmp.Version2c, m.Security.merge(c.Security).resolve("MIKROTIK"), timeout, retries)
+}
+
 func (m *MikroTik) Identify(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return m.IdentifyContext(context.Background(), orig, ip, timeout, retries)
+}
 
-	community := env(m.Community, "MIKROTIK_COMMUNITY", "public")
+func (m *MikroTik) IdentifyContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
 
-	var snmp = &gosnmp.GoSNMP{
-		Target:    ip.String(),
-		Port:      161,
-		Community: community,
-		Version:   gosnmp.Version2c,
-		Timeout:   timeout,
-		Retries:   retries,
-	}
-	if err := snmp.Connect(); err != nil {
+	snmp, err := m.connect(ctx, ip, timeout, retries)
+	if err != nil {
 		return nil, err
 	}
 	defer snmp.Conn.Close()
+	defer watch(ctx, snmp)()
 
 	oid, err := sysObjectID(snmp)
 	if oid == nil || err != nil {
@@ -142,3 +149,45 @@ func (m *MikroTik) Identify(orig string, ip net.IP, timeout time.Duration, retri
 
 	return &s, nil
 }
+
+func (m *MikroTik) Status(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return m.StatusContext(context.Background(), orig, ip, timeout, retries)
+}
+
+func (m *MikroTik) StatusContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+
+	snmp, err := m.connect(ctx, ip, timeout, retries)
+	if err != nil {
+		return nil, err
+	}
+	defer snmp.Conn.Close()
+	defer watch(ctx, snmp)()
+
+	s, err := status(snmp)
+	if s == nil || err != nil {
+		return nil, err
+	}
+
+	// optional health values, in tenths of a volt and degree
+	r, err := snmp.Get([]string{
+		".1.3.6.1.4.1.14988.1.1.3.8.0",
+		".1.3.6.1.4.1.14988.1.1.3.10.0",
+	})
+	if r == nil || err != nil {
+		return s, nil
+	}
+
+	for _, v := range r.Variables {
+		switch v.Type {
+		case gosnmp.Integer:
+			switch v.Name {
+			case ".1.3.6.1.4.1.14988.1.1.3.8.0":
+				s.Values["voltage"] = float64(v.Value.(int)) / 10.0
+			case ".1.3.6.1.4.1.14988.1.1.3.10.0":
+				s.Values["temperature"] = float64(v.Value.(int)) / 10.0
+			}
+		}
+	}
+
+	return s, nil
+}
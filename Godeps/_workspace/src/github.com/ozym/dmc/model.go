@@ -1,6 +1,7 @@
 package dmc
 
 import (
+	"context"
 	"encoding/json"
 	"net"
 	"time"
@@ -110,6 +111,23 @@ type Model interface {
 	Identify(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error)
 }
 
+// The StatusReporter interface is optionally implemented by Models which can
+// recover operational state of health values separately from their identity.
+type StatusReporter interface {
+	Status(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error)
+}
+
+// The ContextModel interface is optionally implemented by Models which can
+// abandon an identification once the given context has been cancelled.
+type ContextModel interface {
+	IdentifyContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error)
+}
+
+// The ContextStatusReporter interface is the cancellable form of StatusReporter.
+type ContextStatusReporter interface {
+	StatusContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error)
+}
+
 // ModelList allows looping over the set of defined device Models.
 var ModelList = []Model{
 	&MikroTik{},
@@ -1,8 +1,10 @@
 package dmc
 
 import (
+	"context"
 	"net"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -10,6 +12,8 @@ import (
 )
 
 type Quanterra struct {
+	// Mass position tolerance, in boom counts, before a recentre is flagged.
+	Tolerance int
 }
 
 func (q *Quanterra) Name() string {
@@ -33,31 +37,109 @@ func (q *Quanterra) Group(g ModelType) bool {
 	}
 }
 
-func (q *Quanterra) Identify(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
-
+// the expected configuration port is tried first
+func (q *Quanterra) ports(orig string) []string {
 	switch {
 	case strings.HasSuffix(orig, "+"):
-		if s, _ := q.discover(ip, "6330", timeout); s != nil {
-			return s, nil
-		}
-		if s, _ := q.discover(ip, "5330", timeout); s != nil {
-			return s, nil
-		}
+		return []string{"6330", "5330"}
 	default:
-		if s, _ := q.discover(ip, "5330", timeout); s != nil {
+		return []string{"5330", "6330"}
+	}
+}
+
+func (q *Quanterra) Identify(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return q.IdentifyContext(context.Background(), orig, ip, timeout, retries)
+}
+
+func (q *Quanterra) IdentifyContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+
+	for _, p := range q.ports(orig) {
+		if s, _ := q.discover(ctx, ip, p, timeout); s != nil {
 			return s, nil
 		}
-		if s, _ := q.discover(ip, "6330", timeout); s != nil {
-			return s, nil
+	}
+
+	return nil, nil
+}
+
+// Status recovers the datalogger identity together with its state of health.
+func (q *Quanterra) Status(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return q.StatusContext(context.Background(), orig, ip, timeout, retries)
+}
+
+func (q *Quanterra) StatusContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+
+	for _, p := range q.ports(orig) {
+		s, _ := q.discover(ctx, ip, p, timeout)
+		if s == nil {
+			continue
+		}
+
+		soh, err := qdp.ReadSOHContext(ctx, ip.String(), p, timeout)
+		if soh == nil || err != nil {
+			return s, err
 		}
+
+		q.health(s, soh)
+
+		return s, nil
 	}
 
 	return nil, nil
 }
 
-func (q *Quanterra) discover(ip net.IP, port string, timeout time.Duration) (*State, error) {
+// health converts the raw status values into engineering units.
+func (q *Quanterra) health(s *State, soh *qdp.SOH) {
+
+	tolerance := q.Tolerance
+	if !(tolerance > 0) {
+		if n, err := strconv.Atoi(env("", "QUANTERRA_MASS_TOLERANCE", "35")); err == nil {
+			tolerance = n
+		}
+	}
+
+	// clock quality is a percentage, loss is in minutes
+	s.Values["quality"] = float64(soh.Global.ClockQual)
+	s.Values["loss"] = int(soh.Global.ClockLoss) * 60
+	s.Values["resyncs"] = int(soh.Global.Resyncs)
+	s.Values["resync"] = soh.Global.LastResync.Unix()
+	s.Values["reboot"] = soh.Header.LastReboot.UTC().Format(time.RFC3339)
+	s.Values["uptime"] = int(time.Since(soh.Header.LastReboot) / time.Second)
+
+	// supply in 150mV steps, temperature in degrees, currents in mA
+	s.Values["voltage"] = float64(soh.Boom.Supply) * 0.15
+	s.Values["temperature"] = float64(soh.Boom.SysTemp)
+	s.Values["current"] = float64(soh.Boom.MainCur)
+	s.Values["antenna"] = float64(soh.Boom.AntCur)
+
+	// gps receiver details
+	s.Values["satellites"] = int(soh.GPS.SatUsed)
+	s.Values["visible"] = int(soh.GPS.SatView)
+	s.Values["gps"] = int(soh.GPS.GPSOn)
+	s.Values["fix"] = soh.GPS.LastGood.Unix()
+
+	// mass positions, flag any outside the recentre tolerance
+	var recentre []string
+	for i, b := range soh.Boom.Booms {
+		s.Values["boom"+strconv.Itoa(i+1)] = int(b)
+		if int(b) > tolerance || int(b) < -tolerance {
+			recentre = append(recentre, strconv.Itoa(i+1))
+		}
+	}
+	s.Values["masses"] = len(recentre)
+	switch {
+	case len(recentre) > 0:
+		s.Values["mass"] = "recentre booms " + strings.Join(recentre, ",")
+	default:
+		s.Values["mass"] = "ok"
+	}
+
+	s.Values["timestamp"] = soh.Timestamp.Unix()
+}
+
+func (q *Quanterra) discover(ctx context.Context, ip net.IP, port string, timeout time.Duration) (*State, error) {
 
-	ans, err := qdp.ReadSerial(ip.String(), port, timeout)
+	ans, err := qdp.ReadSerialContext(ctx, ip.String(), port, timeout)
 	if ans == nil || err != nil {
 		return nil, err
 	}
@@ -1,6 +1,7 @@
 package dmc
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
@@ -13,6 +14,8 @@ import (
 )
 
 type Rock struct {
+	Username string
+	Password string
 }
 
 func (r *Rock) Name() string {
@@ -37,32 +40,47 @@ func (r *Rock) Group(g ModelType) bool {
 }
 
 func (r *Rock) Identify(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return r.IdentifyContext(context.Background(), orig, ip, timeout, retries)
+}
+
+func (r *Rock) IdentifyContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+
+	c := credential(r.Name(), ip)
 
-	transport := digest.NewTransport("rock", "kmi")
+	username := env(def(c.Username, r.Username), "ROCK_USERNAME", "rock")
+	password := env(def(c.Password, r.Password), "ROCK_PASSWORD", "kmi")
+
+	transport := digest.NewTransport(username, password)
 	cookieJar, _ := cookiejar.New(nil)
 
 	client := &http.Client{
 		Transport: transport,
 		Jar:       cookieJar,
+		Timeout:   timeout,
 	}
 
 	s := State{Values: make(map[string]interface{})}
 
-	resp, err := client.Get("http://" + ip.String() + "/")
+	resp, err := get(ctx, client, "http://"+ip.String()+"/")
 	if resp == nil || err != nil {
 		return nil, err
 	}
-	defer client.Get("http://" + ip.String() + "/logoff")
+	resp.Body.Close()
+
+	defer func() {
+		if resp, err := client.Get("http://" + ip.String() + "/logoff"); resp != nil && err == nil {
+			resp.Body.Close()
+		}
+	}()
 
-	resp, err = client.Get("http://" + ip.String() + "/menuload")
+	resp, err = get(ctx, client, "http://"+ip.String()+"/menuload")
 	if resp == nil || err != nil {
 		// there's a bug in golang http which means it may work the next time ...
-		resp, err = client.Get("http://" + ip.String() + "/menuload")
+		resp, err = get(ctx, client, "http://"+ip.String()+"/menuload")
 		if resp == nil || err != nil {
 			return nil, err
 		}
 	}
-	defer client.Get("http://" + ip.String() + "/logoff")
 
 	doc, err := goquery.NewDocumentFromResponse(resp)
 	if doc == nil || err != nil {
@@ -95,15 +113,14 @@ func (r *Rock) Identify(orig string, ip net.IP, timeout time.Duration, retries i
 		}
 	})
 
-	resp, err = client.Get("http://" + ip.String() + "/homeload")
+	resp, err = get(ctx, client, "http://"+ip.String()+"/homeload")
 	if resp == nil || err != nil {
 		// there's a bug in golang http which means it may work the next time ...
-		resp, err = client.Get("http://" + ip.String() + "/homeload")
+		resp, err = get(ctx, client, "http://"+ip.String()+"/homeload")
 		if resp == nil || err != nil {
 			return nil, err
 		}
 	}
-	defer client.Get("http://" + ip.String() + "/logoff")
 
 	doc, err = goquery.NewDocumentFromResponse(resp)
 	if doc == nil || err != nil {
@@ -0,0 +1,76 @@
+package dmc
+
+// The Servicer interface is optionally implemented by Models to give the tcp port
+// of their management service, it allows a device to be checked without using icmp.
+// A zero port indicates the Model has no tcp service.
+type Servicer interface {
+	Service() int
+}
+
+// ServicePort finds the tcp management port of the first Model matching the given name.
+func ServicePort(name string) int {
+	for _, m := range ModelList {
+		if !m.MatchString(name) {
+			continue
+		}
+		if s, ok := m.(Servicer); ok {
+			if p := s.Service(); p > 0 {
+				return p
+			}
+		}
+	}
+	return 0
+}
+
+// winbox
+func (m *MikroTik) Service() int {
+	return 8291
+}
+
+func (m *Ubiquiti) Service() int {
+	return 80
+}
+
+func (f *Freewave) Service() int {
+	return 80
+}
+
+func (h *Hongdian) Service() int {
+	return 80
+}
+
+func (v *ViPR) Service() int {
+	return 80
+}
+
+func (r *Rock) Service() int {
+	return 80
+}
+
+func (t *Trimble) Service() int {
+	return 80
+}
+
+func (c *Cusp) Service() int {
+	return 443
+}
+
+// the qdp configuration ports are udp only
+func (q *Quanterra) Service() int {
+	return 0
+}
+
+func (m *SNMPModel) Service() int {
+	return 0
+}
+
+func (m *HTTPModel) Service() int {
+	switch {
+	case m.Port > 0:
+		return m.Port
+	case m.Scheme == "https":
+		return 443
+	default:
+		return 80
+	}
+}
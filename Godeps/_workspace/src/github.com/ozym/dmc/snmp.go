@@ -1,11 +1,14 @@
 package dmc
 
 import (
+	"context"
 	"fmt"
-
+	"net"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/soniah/gosnmp"
 )
@@ -23,6 +26,149 @@ func env(v, e, d string) string {
 	return def(v, def(os.Getenv(e), d))
 }
 
+// Security holds the SNMPv3 user security model settings for a driver, blank
+// values are taken from the "<PREFIX>_SNMP_*" or "SNMP_*" environment variables.
+// Community (v1/v2c) access is only used when Fallback has been explicitly allowed,
+// either because no v3 username is configured or when a v3 session fails.
+type Security struct {
+	Username       string `yaml:"username"`
+	AuthProtocol   string `yaml:"auth_protocol"` // MD5 or SHA
+	AuthPassphrase string `yaml:"auth_passphrase"`
+	PrivProtocol   string `yaml:"priv_protocol"` // DES or AES
+	PrivPassphrase string `yaml:"priv_passphrase"`
+	Fallback       string `yaml:"fallback"` // "true" allows v1/v2c fallback
+}
+
+func (s Security) resolve(prefix string) Security {
+	get := func(v, name string) string {
+		return env(v, prefix+"_SNMP_"+name, os.Getenv("SNMP_"+name))
+	}
+
+	return Security{
+		Username:       get(s.Username, "USERNAME"),
+		AuthProtocol:   get(s.AuthProtocol, "AUTH_PROTOCOL"),
+		AuthPassphrase: get(s.AuthPassphrase, "AUTH_PASSPHRASE"),
+		PrivProtocol:   get(s.PrivProtocol, "PRIV_PROTOCOL"),
+		PrivPassphrase: get(s.PrivPassphrase, "PRIV_PASSPHRASE"),
+		Fallback:       get(s.Fallback, "FALLBACK"),
+	}
+}
+
+func (s Security) fallback() bool {
+	b, err := strconv.ParseBool(s.Fallback)
+	return b && err == nil
+}
+
+func (s Security) params() (gosnmp.SnmpV3MsgFlags, *gosnmp.UsmSecurityParameters, error) {
+	usm := gosnmp.UsmSecurityParameters{
+		UserName:                 s.Username,
+		AuthenticationProtocol:   gosnmp.NoAuth,
+		PrivacyProtocol:          gosnmp.NoPriv,
+		AuthenticationPassphrase: s.AuthPassphrase,
+		PrivacyPassphrase:        s.PrivPassphrase,
+	}
+
+	flags := gosnmp.NoAuthNoPriv
+
+	switch strings.ToUpper(s.AuthProtocol) {
+	case "", "NONE":
+	case "MD5":
+		usm.AuthenticationProtocol = gosnmp.MD5
+		flags = gosnmp.AuthNoPriv
+	case "SHA":
+		usm.AuthenticationProtocol = gosnmp.SHA
+		flags = gosnmp.AuthNoPriv
+	default:
+		return 0, nil, fmt.Errorf("unknown snmp auth protocol: %s", s.AuthProtocol)
+	}
+
+	switch strings.ToUpper(s.PrivProtocol) {
+	case "", "NONE":
+	case "DES":
+		usm.PrivacyProtocol = gosnmp.DES
+		flags = gosnmp.AuthPriv
+	case "AES":
+		usm.PrivacyProtocol = gosnmp.AES
+		flags = gosnmp.AuthPriv
+	default:
+		return 0, nil, fmt.Errorf("unknown snmp priv protocol: %s", s.PrivProtocol)
+	}
+
+	if flags == gosnmp.AuthPriv && usm.AuthenticationProtocol == gosnmp.NoAuth {
+		return 0, nil, fmt.Errorf("snmp privacy requires an auth protocol")
+	}
+
+	return flags, &usm, nil
+}
+
+// session connects to an snmp agent using v3, community access is only used if fallback is allowed.
+func session(ctx context.Context, ip net.IP, community string, version gosnmp.SnmpVersion, sec Security, timeout time.Duration, retries int) (*gosnmp.GoSNMP, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	legacy := func() (*gosnmp.GoSNMP, error) {
+		var snmp = &gosnmp.GoSNMP{
+			Target:    ip.String(),
+			Port:      161,
+			Community: community,
+			Version:   version,
+			Timeout:   timeout,
+			Retries:   retries,
+		}
+		if err := snmp.Connect(); err != nil {
+			return nil, err
+		}
+		return snmp, nil
+	}
+
+	if sec.Username == "" {
+		if !sec.fallback() {
+			return nil, fmt.Errorf("no snmp v3 username configured and community fallback is not allowed")
+		}
+		return legacy()
+	}
+
+	flags, usm, err := sec.params()
+	if err != nil {
+		return nil, err
+	}
+
+	var snmp = &gosnmp.GoSNMP{
+		Target:             ip.String(),
+		Port:               161,
+		Version:            gosnmp.Version3,
+		MsgFlags:           flags,
+		SecurityModel:      gosnmp.UserSecurityModel,
+		SecurityParameters: usm,
+		Timeout:            timeout,
+		Retries:            retries,
+	}
+	if err := snmp.Connect(); err != nil {
+		return nil, err
+	}
+
+	if !sec.fallback() {
+		return snmp, nil
+	}
+
+	// check the v3 session works before deciding whether to fall back
+	stop := watch(ctx, snmp)
+	if _, err := sysObjectID(snmp); err == nil {
+		stop()
+		return snmp, nil
+	}
+	stop()
+	snmp.Conn.Close()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return legacy()
+}
+
 func index(oid string) int {
 	f := strings.Split(oid, ".")
 
@@ -36,6 +182,20 @@ func index(oid string) int {
 	return 0
 }
 
+// watch closes the snmp connection if the context is cancelled, unblocking any
+// pending requests, the returned function should be called once finished with.
+func watch(ctx context.Context, snmp *gosnmp.GoSNMP) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			snmp.Conn.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
 func sysObjectID(snmp *gosnmp.GoSNMP) (*string, error) {
 	r, err := snmp.Get([]string{
 		".1.3.6.1.2.1.1.2.0",
@@ -60,28 +220,95 @@ func sysObjectID(snmp *gosnmp.GoSNMP) (*string, error) {
 	return oid, nil
 }
 
+func sysUpTime(snmp *gosnmp.GoSNMP) (*uint32, error) {
+	r, err := snmp.Get([]string{
+		".1.3.6.1.2.1.1.3.0",
+	})
+	if r == nil || err != nil {
+		return nil, err
+	}
+
+	var ticks *uint32
+	for _, v := range r.Variables {
+		switch v.Type {
+		case gosnmp.TimeTicks:
+			switch v.Name {
+			case ".1.3.6.1.2.1.1.3.0":
+				if n, ok := v.Value.(int); ok {
+					t := (uint32)(n)
+					ticks = &t
+				}
+			}
+		}
+	}
+
+	return ticks, nil
+}
+
+// status builds the common snmp state of health values.
+func status(snmp *gosnmp.GoSNMP) (*State, error) {
+	ticks, err := sysUpTime(snmp)
+	if ticks == nil || err != nil {
+		return nil, err
+	}
+
+	s := State{Values: make(map[string]interface{})}
+
+	// uptime is given in hundredths of a second
+	s.Values["uptime"] = int(*ticks / 100)
+	s.Values["timestamp"] = time.Now().Unix()
+
+	// interface traffic counters, if available
+	if inf, err := interfaces(snmp); err == nil && len(inf) > 0 {
+		s.Values["interfaces"] = inf
+	}
+
+	return &s, nil
+}
+
+// sysInterface holds the traffic counters of a single interface, the 64 bit
+// ifXTable counters are used for octets when the device provides them.
 type sysInterface struct {
 	Name string
 	Type int
+	Bits int
+
+	RX uint64
+	TX uint64
+
+	RXErrors   uint64
+	TXErrors   uint64
+	RXDiscards uint64
+	TXDiscards uint64
+}
 
-	RX *uint32
-	TX *uint32
+func counter(v gosnmp.SnmpPDU) (uint64, bool) {
+	switch n := v.Value.(type) {
+	case uint:
+		return uint64(n), true
+	case int64:
+		return uint64(n), true
+	case uint64:
+		return n, true
+	default:
+		return 0, false
+	}
 }
 
-func sysInterfaces(snmp *gosnmp.GoSNMP) (map[int]sysInterface, error) {
+func sysInterfaces(snmp *gosnmp.GoSNMP) (map[int]*sysInterface, error) {
 
 	w, err := snmp.WalkAll(".1.3.6.1.2.1.2.2.1")
 	if err != nil {
 		return nil, err
 	}
 
-	ids := make(map[int]sysInterface)
+	ids := make(map[int]*sysInterface)
 	for _, v := range w {
 		switch v.Type {
 		case gosnmp.OctetString:
 			switch {
-			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.2.2.1.2"):
-				ids[index(v.Name)] = sysInterface{Name: string(v.Value.([]byte))}
+			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.2.2.1.2."):
+				ids[index(v.Name)] = &sysInterface{Name: string(v.Value.([]byte)), Bits: 32}
 			}
 		}
 	}
@@ -93,48 +320,102 @@ func sysInterfaces(snmp *gosnmp.GoSNMP) (map[int]sysInterface, error) {
 		switch v.Type {
 		case gosnmp.Integer:
 			switch {
-			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.2.2.1.3"):
+			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.2.2.1.3."):
 				i.Type = v.Value.(int)
 			}
 		case gosnmp.Counter32:
+			n, _ := counter(v)
 			switch {
-			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.2.2.1.10"):
-				if n := (uint32)(v.Value.(uint)); true {
-					i.RX = &n
-				}
-			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.2.2.1.16"):
-				if n := (uint32)(v.Value.(uint)); true {
-					i.TX = &n
+			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.2.2.1.10."):
+				i.RX = n
+			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.2.2.1.13."):
+				i.RXDiscards = n
+			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.2.2.1.14."):
+				i.RXErrors = n
+			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.2.2.1.16."):
+				i.TX = n
+			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.2.2.1.19."):
+				i.TXDiscards = n
+			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.2.2.1.20."):
+				i.TXErrors = n
+			}
+		}
+	}
+
+	// the ifXTable is optional, v1 agents can't return the high capacity counters
+	x, err := snmp.WalkAll(".1.3.6.1.2.1.31.1.1.1")
+	if err != nil {
+		return ids, nil
+	}
+	for _, v := range x {
+		i, ok := ids[index(v.Name)]
+		if !ok {
+			continue
+		}
+		switch v.Type {
+		case gosnmp.OctetString:
+			switch {
+			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.31.1.1.1.1."):
+				if n := string(v.Value.([]byte)); n != "" {
+					i.Name = n
 				}
 			}
+		case gosnmp.Counter64:
+			n, _ := counter(v)
+			switch {
+			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.31.1.1.1.6."):
+				i.RX, i.Bits = n, 64
+			case strings.HasPrefix(v.Name, ".1.3.6.1.2.1.31.1.1.1.10."):
+				i.TX, i.Bits = n, 64
+			}
 		}
-		ids[index(v.Name)] = i
 	}
 
 	return ids, nil
 }
 
-func interfaces(snmp *gosnmp.GoSNMP, iftype int) ([]interface{}, error) {
+// interfaces lists the traffic counters of the given interface types, or all interfaces if none are given.
+func interfaces(snmp *gosnmp.GoSNMP, types ...int) ([]interface{}, error) {
 	ids, err := sysInterfaces(snmp)
 	if err != nil {
 		return nil, err
 	}
 
-	var inf = make([]interface{}, 0)
-	for _, k := range ids {
-		if k.Type != iftype {
-			continue
-		}
+	var keys []int
+	for k := range ids {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
 
-		t := make(map[string]interface{})
+	var inf = make([]interface{}, 0)
+	for _, k := range keys {
+		i := ids[k]
 
-		t["name"] = k.Name
-		t["rx"] = k.RX
-		t["tx"] = k.TX
+		if len(types) > 0 {
+			var found bool
+			for _, t := range types {
+				if i.Type == t {
+					found = true
+				}
+			}
+			if !found {
+				continue
+			}
+		}
 
-		inf = append(inf, t)
+		inf = append(inf, map[string]interface{}{
+			"index":       k,
+			"name":        i.Name,
+			"type":        i.Type,
+			"bits":        i.Bits,
+			"rx":          i.RX,
+			"tx":          i.TX,
+			"rx_errors":   i.RXErrors,
+			"tx_errors":   i.TXErrors,
+			"rx_discards": i.RXDiscards,
+			"tx_discards": i.TXDiscards,
+		})
 	}
 
-	fmt.Println("->", inf)
 	return inf, nil
 }
@@ -1,6 +1,7 @@
 package dmc
 
 import (
+	"context"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -36,20 +37,45 @@ func (t *Trimble) Group(g ModelType) bool {
 	}
 }
 
+// the NetRS and NetR9 receivers have different default credentials
+func (t *Trimble) credentials(model string, ip net.IP) (string, string) {
+	c := credential(model, ip)
+
+	switch model {
+	case "Trimble NetR9":
+		return env(def(c.Username, t.Username), "NETR9_USERNAME", "admin"), env(def(c.Password, t.Password), "NETR9_PASSWORD", "")
+	default:
+		return env(def(c.Username, t.Username), "NETRS_USERNAME", "sysadmin"), env(def(c.Password, t.Password), "NETRS_PASSWORD", "")
+	}
+}
+
 func (t *Trimble) Identify(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return t.IdentifyContext(context.Background(), orig, ip, timeout, retries)
+}
+
+func (t *Trimble) IdentifyContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+
+	try := func(model string) *State {
+		username, password := t.credentials(model, ip)
+		if s, err := t.identify(ctx, username, password, ip, timeout, retries); s != nil && err == nil {
+			return s
+		}
+		return nil
+	}
+
 	switch {
 	case strings.Contains(orig, "NetRS"):
-		if s, err := t.IdentifyNetRS(ip, timeout, retries); s != nil && err == nil {
+		if s := try("Trimble NetRS"); s != nil {
 			return s, nil
 		}
-		if s, err := t.IdentifyNetR9(ip, timeout, retries); s != nil && err == nil {
+		if s := try("Trimble NetR9"); s != nil {
 			return s, nil
 		}
 	case strings.Contains(orig, "NetR9"):
-		if s, err := t.IdentifyNetR9(ip, timeout, retries); s != nil && err == nil {
+		if s := try("Trimble NetR9"); s != nil {
 			return s, nil
 		}
-		if s, err := t.IdentifyNetRS(ip, timeout, retries); s != nil && err == nil {
+		if s := try("Trimble NetRS"); s != nil {
 			return s, nil
 		}
 	}
@@ -57,27 +83,25 @@ func (t *Trimble) Identify(orig string, ip net.IP, timeout time.Duration, retrie
 }
 
 func (t *Trimble) IdentifyNetRS(ip net.IP, timeout time.Duration, retries int) (*State, error) {
-	username := env(t.Username, "NETRS_USERNAME", "sysadmin")
-	password := env(t.Password, "NETRS_PASSWORD", "")
+	username, password := t.credentials("Trimble NetRS", ip)
 
-	return t.identify(username, password, ip, timeout, retries)
+	return t.identify(context.Background(), username, password, ip, timeout, retries)
 }
 
 func (t *Trimble) IdentifyNetR9(ip net.IP, timeout time.Duration, retries int) (*State, error) {
-	username := env(t.Username, "NETR9_USERNAME", "admin")
-	password := env(t.Password, "NETR9_PASSWORD", "")
+	username, password := t.credentials("Trimble NetR9", ip)
 
-	return t.identify(username, password, ip, timeout, retries)
+	return t.identify(context.Background(), username, password, ip, timeout, retries)
 }
 
-func (t *Trimble) identify(username, password string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+func (t *Trimble) identify(ctx context.Context, username, password string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
 
-	cli := &http.Client{}
+	cli := &http.Client{Timeout: timeout}
 
 	s := State{Values: make(map[string]interface{})}
 
 	for _, n := range []string{"serialNumber", "FirmwareVersion", "RefStation"} {
-		r, err := t.show(cli, username, password, ip, n)
+		r, err := t.show(ctx, cli, username, password, ip, n)
 		if err != nil {
 			return nil, err
 		}
@@ -125,9 +149,58 @@ func (t *Trimble) identify(username, password string, ip net.IP, timeout time.Du
 	return &s, nil
 }
 
-func (t *Trimble) show(cli *http.Client, username, password string, ip net.IP, value string) (string, error) {
+func (t *Trimble) Status(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return t.StatusContext(context.Background(), orig, ip, timeout, retries)
+}
+
+func (t *Trimble) StatusContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+
+	username, password := t.credentials("Trimble NetRS", ip)
+	if strings.Contains(orig, "NetR9") {
+		username, password = t.credentials("Trimble NetR9", ip)
+	}
+
+	cli := &http.Client{Timeout: timeout}
+
+	s := State{Values: make(map[string]interface{})}
+
+	for _, n := range []string{"Voltages", "Temperature"} {
+		r, err := t.show(ctx, cli, username, password, ip, n)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range strings.Fields(r) {
+			if b := strings.Split(a, "="); len(b) > 1 {
+				switch b[0] {
+				case "volts":
+					// only the first (primary) supply port is kept
+					if _, ok := s.Values["voltage"]; ok {
+						continue
+					}
+					if v, err := strconv.ParseFloat(b[1], 64); err == nil {
+						s.Values["voltage"] = v
+					}
+				case "temp":
+					if v, err := strconv.ParseFloat(b[1], 64); err == nil {
+						s.Values["temperature"] = v
+					}
+				}
+			}
+		}
+	}
+
+	if !(len(s.Values) > 0) {
+		return nil, nil
+	}
+
+	s.Values["timestamp"] = time.Now().Unix()
+
+	return &s, nil
+}
+
+func (t *Trimble) show(ctx context.Context, cli *http.Client, username, password string, ip net.IP, value string) (string, error) {
 
-	request, err := http.NewRequest("GET", "http://"+ip.String()+"/prog/show?"+value, nil)
+	request, err := http.NewRequestWithContext(ctx, "GET", "http://"+ip.String()+"/prog/show?"+value, nil)
 	if err != nil {
 		return "", err
 	}
@@ -1,6 +1,7 @@
 package dmc
 
 import (
+	"context"
 	"net"
 	"regexp"
 	"time"
@@ -10,6 +11,7 @@ import (
 
 type Ubiquiti struct {
 	Community string
+	Security  Security
 }
 
 func (m *Ubiquiti) Name() string {
@@ -33,22 +35,27 @@ func (m *Ubiquiti) Group(g ModelType) bool {
 	}
 }
 
+func (m *Ubiquiti) connect(ctx context.Context, ip net.IP, timeout time.Duration, retries int) (*gosnmp.GoSNMP, error) {
+
+	c := credential(m.Name(), ip)
+
+	community := env(def(c.Community, m.Community), "UBIQUITY_COMMUNITY", "public")
+
+	return session(ctx, ip, community, gosnmp.Version1, m.Security.merge(c.Security).resolve("UBIQUITY"), timeout, retries)
+}
+
 func (m *Ubiquiti) Identify(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return m.IdentifyContext(context.Background(), orig, ip, timeout, retries)
+}
 
-	community := env(m.Community, "UBIQUITY_COMMUNITY", "public")
+func (m *Ubiquiti) IdentifyContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
 
-	var snmp = &gosnmp.GoSNMP{
-		Target:    ip.String(),
-		Port:      161,
-		Community: community,
-		Version:   gosnmp.Version1,
-		Timeout:   timeout,
-		Retries:   retries,
-	}
-	if err := snmp.Connect(); err != nil {
+	snmp, err := m.connect(ctx, ip, timeout, retries)
+	if err != nil {
 		return nil, err
 	}
 	defer snmp.Conn.Close()
+	defer watch(ctx, snmp)()
 
 	oid, err := sysObjectID(snmp)
 	if oid == nil || err != nil {
@@ -111,3 +118,19 @@ func (m *Ubiquiti) Identify(orig string, ip net.IP, timeout time.Duration, retri
 
 	return &s, nil
 }
+
+func (m *Ubiquiti) Status(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return m.StatusContext(context.Background(), orig, ip, timeout, retries)
+}
+
+func (m *Ubiquiti) StatusContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+
+	snmp, err := m.connect(ctx, ip, timeout, retries)
+	if err != nil {
+		return nil, err
+	}
+	defer snmp.Conn.Close()
+	defer watch(ctx, snmp)()
+
+	return status(snmp)
+}
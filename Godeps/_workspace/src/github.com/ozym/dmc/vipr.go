@@ -1,6 +1,7 @@
 package dmc
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"regexp"
@@ -37,16 +38,25 @@ func (v *ViPR) MatchString(s string) bool {
 }
 
 func (v *ViPR) Identify(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return v.IdentifyContext(context.Background(), orig, ip, timeout, retries)
+}
+
+func (v *ViPR) IdentifyContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
 
 	s := State{Values: make(map[string]interface{})}
 
 	// default model
 	s.Values["model"] = "ViPR Radio"
 
-	cli := &http.Client{}
+	c := credential(v.Name(), ip)
+
+	user := env(def(c.Username, v.Username), "VIPR_USERNAME", "Admin")
+	password := env(def(c.Password, v.Password), "VIPR_PASSWORD", "ADMINISTRATOR")
+
+	cli := &http.Client{Timeout: timeout}
 	pages := []string{"UStatus.html", "ViPRDiag.html", "IPSetting.html"}
 	for _, p := range pages {
-		r, err := v.discover(cli, "http://"+ip.String()+"/"+p)
+		r, err := v.discover(ctx, cli, user, password, "http://"+ip.String()+"/"+p)
 		if err != nil {
 			return nil, err
 		}
@@ -82,12 +92,9 @@ func (v *ViPR) Identify(orig string, ip net.IP, timeout time.Duration, retries i
 	return &s, nil
 }
 
-func (v *ViPR) discover(cli *http.Client, url string) (map[string]string, error) {
-
-	user := env(v.Username, "VIPR_USERNAME", "Admin")
-	password := env(v.Password, "VIPR_PASSWORD", "ADMINISTRATOR")
+func (v *ViPR) discover(ctx context.Context, cli *http.Client, user, password, url string) (map[string]string, error) {
 
-	request, err := http.NewRequest("GET", url, nil)
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,482 @@
+package dmc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.google.com/p/mlab-ns2/gae/ns/digest"
+	"github.com/PuerkitoBio/goquery"
+	"gopkg.in/yaml.v2"
+)
+
+// HTTPAuth describes how to log into a web managed device.
+type HTTPAuth struct {
+	Type     string            `yaml:"type"` // none, basic, digest or form
+	Path     string            `yaml:"path"` // form login page
+	Username string            `yaml:"username_field"`
+	Password string            `yaml:"password_field"`
+	Fields   map[string]string `yaml:"fields"` // extra form values
+	Logout   string            `yaml:"logout"`
+}
+
+// HTTPExtract pulls a single value from a page, either using a css selector or a
+// regular expression over the raw page. A selector may be limited to elements
+// containing some text, and the value taken from an attribute or the next element.
+type HTTPExtract struct {
+	Key      string  `yaml:"key"`
+	Selector string  `yaml:"selector"`
+	Contains string  `yaml:"contains"`
+	Attr     string  `yaml:"attr"`
+	Next     bool    `yaml:"next"`
+	Regex    string  `yaml:"regex"` // the first sub match is used if given
+	Type     string  `yaml:"type"`  // string, int or float
+	Scale    float64 `yaml:"scale"`
+
+	regex *regexp.Regexp
+}
+
+// HTTPPage lists the values to extract from a device page.
+type HTTPPage struct {
+	Path    string        `yaml:"path"`
+	Extract []HTTPExtract `yaml:"extract"`
+}
+
+// HTTPLabel sets the model label from an extracted value.
+type HTTPLabel struct {
+	Key   string `yaml:"key"`
+	Match string `yaml:"match"`
+	Label string `yaml:"label"`
+
+	match *regexp.Regexp
+}
+
+// HTTPModel is a generic web scraping driver built from a yaml definition.
+type HTTPModel struct {
+	Label    string      `yaml:"name"`
+	Pattern  string      `yaml:"match"`
+	Members  []string    `yaml:"groups"`
+	Env      string      `yaml:"env"`
+	Scheme   string      `yaml:"scheme"`
+	Port     int         `yaml:"port"`
+	Insecure bool        `yaml:"insecure"`
+	Banner   string      `yaml:"banner"` // expected http Server or WWW-Authenticate text
+	Username string      `yaml:"username"`
+	Password string      `yaml:"password"`
+	Auth     HTTPAuth    `yaml:"auth"`
+	Pages    []HTTPPage  `yaml:"pages"`
+	Required []string    `yaml:"required"`
+	Default  string      `yaml:"default"`
+	Labels   []HTTPLabel `yaml:"labels"`
+
+	pattern *regexp.Regexp
+	groups  []ModelType
+}
+
+func (m *HTTPModel) compile() error {
+	var err error
+
+	if m.Label == "" {
+		return fmt.Errorf("http model is missing a name")
+	}
+	if !(len(m.Pages) > 0) {
+		return fmt.Errorf("http model %s has no pages", m.Label)
+	}
+	// otherwise any web server would be identified
+	if !(len(m.Required) > 0) && m.Banner == "" {
+		return fmt.Errorf("http model %s needs required keys or a banner", m.Label)
+	}
+	if m.Pattern == "" {
+		m.Pattern = "^" + regexp.QuoteMeta(m.Label)
+	}
+	if m.pattern, err = regexp.Compile(m.Pattern); err != nil {
+		return err
+	}
+	for _, g := range m.Members {
+		t, ok := groupNames[strings.ToLower(g)]
+		if !ok {
+			return fmt.Errorf("http model %s has an unknown group: %s", m.Label, g)
+		}
+		m.groups = append(m.groups, t)
+	}
+	switch m.Scheme {
+	case "":
+		m.Scheme = "http"
+	case "http", "https":
+	default:
+		return fmt.Errorf("http model %s has an unknown scheme: %s", m.Label, m.Scheme)
+	}
+	switch strings.ToLower(m.Auth.Type) {
+	case "", "none", "basic", "digest":
+	case "form":
+		if m.Auth.Path == "" {
+			return fmt.Errorf("http model %s form auth is missing a path", m.Label)
+		}
+	default:
+		return fmt.Errorf("http model %s has an unknown auth type: %s", m.Label, m.Auth.Type)
+	}
+	for i := range m.Pages {
+		for j := range m.Pages[i].Extract {
+			x := &m.Pages[i].Extract[j]
+			if x.Key == "" {
+				return fmt.Errorf("http model %s has an extract without a key", m.Label)
+			}
+			if x.Selector == "" && x.Regex == "" {
+				return fmt.Errorf("http model %s key %s needs a selector or regex", m.Label, x.Key)
+			}
+			if x.Regex != "" {
+				if x.regex, err = regexp.Compile(x.Regex); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for i := range m.Labels {
+		if m.Labels[i].match, err = regexp.Compile(m.Labels[i].Match); err != nil {
+			return err
+		}
+	}
+	if m.Env == "" {
+		m.Env = strings.ToUpper(regexp.MustCompile("[^A-Za-z0-9]+").ReplaceAllString(m.Label, "_"))
+	}
+
+	return nil
+}
+
+// LoadHTTPModels decodes a yaml list of web scraping model definitions.
+func LoadHTTPModels(data []byte) ([]Model, error) {
+	var defs []*HTTPModel
+	if err := yaml.Unmarshal(data, &defs); err != nil {
+		return nil, err
+	}
+
+	var models []Model
+	for _, d := range defs {
+		if err := d.compile(); err != nil {
+			return nil, err
+		}
+		models = append(models, d)
+	}
+
+	return models, nil
+}
+
+func (m *HTTPModel) Name() string {
+	return m.Label
+}
+
+func (m *HTTPModel) MatchString(s string) bool {
+	return m.pattern.MatchString(s)
+}
+
+func (m *HTTPModel) Groups() []ModelType {
+	return m.groups
+}
+
+func (m *HTTPModel) Group(g ModelType) bool {
+	for _, t := range m.groups {
+		if t == g {
+			return true
+		}
+	}
+	return false
+}
+
+// get issues a context bound request
+func get(ctx context.Context, cli *http.Client, url string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return cli.Do(request)
+}
+
+// build a client which handles the authentication, form logins are done up front
+func (m *HTTPModel) client(ctx context.Context, base string, ip net.IP, timeout time.Duration) (*http.Client, string, string, error) {
+
+	c := credential(m.Name(), ip)
+
+	username := env(def(c.Username, m.Username), m.Env+"_USERNAME", "admin")
+	password := env(def(c.Password, m.Password), m.Env+"_PASSWORD", "admin")
+
+	var transport http.RoundTripper = &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: m.Insecure},
+	}
+
+	if strings.ToLower(m.Auth.Type) == "digest" {
+		t := digest.NewTransport(username, password)
+		t.Transport = transport
+		transport = t
+	}
+
+	jar, _ := cookiejar.New(nil)
+
+	cli := &http.Client{
+		Transport: transport,
+		Jar:       jar,
+		Timeout:   timeout,
+	}
+
+	if strings.ToLower(m.Auth.Type) == "form" {
+		form := url.Values{}
+		for k, v := range m.Auth.Fields {
+			form.Set(k, v)
+		}
+		form.Set(def(m.Auth.Username, "username"), username)
+		form.Set(def(m.Auth.Password, "password"), password)
+
+		request, err := http.NewRequestWithContext(ctx, "POST", base+m.Auth.Path, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, "", "", err
+		}
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := cli.Do(request)
+		if resp == nil || err != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, "", "", err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 400 {
+			return nil, "", "", fmt.Errorf("%s login failed: %s", m.Name(), resp.Status)
+		}
+	}
+
+	return cli, username, password, nil
+}
+
+// banner gets the unauthenticated http Server and WWW-Authenticate headers of the device
+func (m *HTTPModel) banner(ctx context.Context, base string, timeout time.Duration) (string, error) {
+	cli := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: m.Insecure}},
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := get(ctx, cli, base+"/")
+	if resp == nil || err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	return strings.TrimSpace(resp.Header.Get("Server") + " " + resp.Header.Get("WWW-Authenticate")), nil
+}
+
+// fetch a page, retrying on connection errors
+func (m *HTTPModel) fetch(ctx context.Context, cli *http.Client, username, password, page string, retries int) ([]byte, error) {
+	var err error
+
+	for i := 0; i <= retries && ctx.Err() == nil; i++ {
+		var request *http.Request
+		if request, err = http.NewRequestWithContext(ctx, "GET", page, nil); err != nil {
+			return nil, err
+		}
+		if strings.ToLower(m.Auth.Type) == "basic" {
+			request.SetBasicAuth(username, password)
+		}
+
+		// the body is closed on every attempt, the client may return one along with an error
+		var resp *http.Response
+		if resp, err = cli.Do(request); resp == nil || err != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			continue
+		}
+
+		if resp.StatusCode >= 400 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", page, resp.Status)
+		}
+
+		raw, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		return raw, err
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return nil, err
+}
+
+// convert an extracted string into the requested value type
+func (x HTTPExtract) convert(text string) (interface{}, bool) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, false
+	}
+
+	switch strings.ToLower(x.Type) {
+	case "int", "integer", "float", "number":
+		f := strings.Fields(text)
+		v, err := strconv.ParseFloat(f[0], 64)
+		if err != nil {
+			return nil, false
+		}
+		if x.Scale != 0 {
+			v = v * x.Scale
+		}
+		if t := strings.ToLower(x.Type); t == "int" || t == "integer" {
+			return int64(math.Floor(v + 0.5)), true
+		}
+		return v, true
+	default:
+		return strings.Join(strings.Fields(text), " "), true
+	}
+}
+
+// apply the optional regular expression, using the first sub match if there is one
+func (x HTTPExtract) search(text string) (string, bool) {
+	if x.regex == nil {
+		return text, true
+	}
+	p := x.regex.FindStringSubmatch(text)
+	switch {
+	case p == nil:
+		return "", false
+	case len(p) > 1:
+		return p[1], true
+	default:
+		return p[0], true
+	}
+}
+
+func (x HTTPExtract) extract(raw []byte, doc *goquery.Document) (interface{}, bool) {
+	if x.Selector == "" {
+		if t, ok := x.search(string(raw)); ok {
+			return x.convert(t)
+		}
+		return nil, false
+	}
+
+	var value interface{}
+	var found bool
+
+	doc.Find(x.Selector).EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if x.Contains != "" && !strings.Contains(s.Text(), x.Contains) {
+			return true
+		}
+		if x.Next {
+			s = s.Next()
+		}
+
+		var text string
+		switch {
+		case x.Attr != "":
+			text, _ = s.Attr(x.Attr)
+		default:
+			text = s.Text()
+		}
+
+		if t, ok := x.search(text); ok {
+			value, found = x.convert(t)
+		}
+
+		return !found
+	})
+
+	return value, found
+}
+
+func (m *HTTPModel) label(s *State) string {
+	for _, l := range m.Labels {
+		v, ok := s.Values[l.Key]
+		if !ok {
+			continue
+		}
+		x := fmt.Sprint(v)
+		if i := l.match.FindStringSubmatchIndex(x); i != nil {
+			return string(l.match.ExpandString(nil, l.Label, x, i))
+		}
+	}
+
+	// an extracted model value is kept if no label rule applies
+	if v, ok := s.Values["model"].(string); ok && v != "" {
+		return v
+	}
+
+	return def(m.Default, m.Label)
+}
+
+func (m *HTTPModel) Identify(orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+	return m.IdentifyContext(context.Background(), orig, ip, timeout, retries)
+}
+
+func (m *HTTPModel) IdentifyContext(ctx context.Context, orig string, ip net.IP, timeout time.Duration, retries int) (*State, error) {
+
+	base := m.Scheme + "://" + ip.String()
+	if m.Port > 0 {
+		base = m.Scheme + "://" + net.JoinHostPort(ip.String(), strconv.Itoa(m.Port))
+	}
+
+	if m.Banner != "" {
+		b, err := m.banner(ctx, base, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.Contains(strings.ToLower(b), strings.ToLower(m.Banner)) {
+			return nil, nil
+		}
+	}
+
+	cli, username, password, err := m.client(ctx, base, ip, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if m.Auth.Logout != "" {
+		defer func() {
+			if resp, err := cli.Get(base + m.Auth.Logout); resp != nil && err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+
+	s := State{Values: make(map[string]interface{})}
+
+	for _, p := range m.Pages {
+		raw, err := m.fetch(ctx, cli, username, password, base+"/"+strings.TrimPrefix(p.Path, "/"), retries)
+		if err != nil {
+			return nil, err
+		}
+
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(raw))
+		if doc == nil || err != nil {
+			return nil, err
+		}
+
+		for _, x := range p.Extract {
+			if v, ok := x.extract(raw, doc); ok {
+				s.Values[x.Key] = v
+			}
+		}
+	}
+
+	for _, k := range m.Required {
+		if _, ok := s.Values[k]; !ok {
+			return nil, nil
+		}
+	}
+
+	s.Values["model"] = m.label(&s)
+
+	return &s, nil
+}
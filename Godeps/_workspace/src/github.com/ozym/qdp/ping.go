@@ -2,6 +2,7 @@ package qdp
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"net"
@@ -130,7 +131,14 @@ func Decode(b []byte) *Ping {
 }
 
 func (p *Ping) Send(ipaddr string, ipport string, timeout time.Duration) (*Ping, error) {
-	conn, err := net.Dial("udp", net.JoinHostPort(ipaddr, ipport))
+	return p.SendContext(context.Background(), ipaddr, ipport, timeout)
+}
+
+// SendContext sends the request, the wait for a reply is abandoned if the context is cancelled.
+func (p *Ping) SendContext(ctx context.Context, ipaddr string, ipport string, timeout time.Duration) (*Ping, error) {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(ipaddr, ipport))
 	if err != nil {
 		return nil, err
 	}
@@ -141,14 +149,33 @@ func (p *Ping) Send(ipaddr string, ipport string, timeout time.Duration) (*Ping,
 		return nil, err
 	}
 
-	err = conn.SetDeadline(time.Now().Add(timeout))
+	deadline := time.Now().Add(timeout)
+	if t, ok := ctx.Deadline(); ok && t.Before(deadline) {
+		deadline = t
+	}
+
+	err = conn.SetDeadline(deadline)
 	if err != nil {
 		return nil, err
 	}
 
+	// unblock the read on cancellation
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
 	b := make([]byte, 512)
 	_, err = conn.Read(b)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, err
 	}
 
@@ -1,9 +1,16 @@
 package qdp
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 func ReadSerial(ipaddr string, ipport string, timeout time.Duration) (*Serial, error) {
-	p, err := NewSerial().Send(ipaddr, ipport, timeout)
+	return ReadSerialContext(context.Background(), ipaddr, ipport, timeout)
+}
+
+func ReadSerialContext(ctx context.Context, ipaddr string, ipport string, timeout time.Duration) (*Serial, error) {
+	p, err := NewSerial().SendContext(ctx, ipaddr, ipport, timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -15,7 +22,11 @@ func ReadSerial(ipaddr string, ipport string, timeout time.Duration) (*Serial, e
 }
 
 func ReadSOH(ipaddr string, ipport string, timeout time.Duration) (*SOH, error) {
-	p, err := NewStatus().Send(ipaddr, ipport, timeout)
+	return ReadSOHContext(context.Background(), ipaddr, ipport, timeout)
+}
+
+func ReadSOHContext(ctx context.Context, ipaddr string, ipport string, timeout time.Duration) (*SOH, error) {
+	p, err := NewStatus().SendContext(ctx, ipaddr, ipport, timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -158,7 +158,7 @@ func decodeValue(data []byte, msg string) (retVal *variable, err error) {
 			slog.Print("decodeValue: type is Counter64")
 		}
 		length, cursor := parseLength(data)
-		ret, err := parseInt64(data[cursor:length])
+		ret, err := parseUint64(data[cursor:length])
 		if err != nil {
 			if LoggingDisabled != true {
 				slog.Printf("decodeValue: err is %v", err)
@@ -581,6 +581,10 @@ func parseUint16(content []byte) int {
 // parseUint64 treats the given bytes as a big-endian, unsigned integer and returns
 // the result.
 func parseUint64(bytes []byte) (ret uint64, err error) {
+	// values with the top bit set are encoded with a leading zero byte
+	if len(bytes) == 9 && bytes[0] == 0 {
+		bytes = bytes[1:]
+	}
 	if len(bytes) > 8 {
 		// We'll overflow a uint64 in this case.
 		err = errors.New("integer too large")
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Policy gives the approved firmware or software versions for devices matching a model and site.
+type Policy struct {
+	Name     string   `yaml:"name"`
+	Model    string   `yaml:"model"`
+	Site     string   `yaml:"site"`
+	Key      string   `yaml:"key"`      // state value holding the version, defaults to the first version key found
+	Minimum  string   `yaml:"minimum"`  // lowest acceptable version
+	Exact    string   `yaml:"exact"`    // the only acceptable version
+	Approved []string `yaml:"approved"` // list of acceptable versions
+
+	selector selector
+}
+
+func (p *Policy) compile() error {
+	var err error
+
+	if p.selector, err = newSelector(p.Model, p.Site); err != nil {
+		return err
+	}
+	if p.Minimum == "" && p.Exact == "" && !(len(p.Approved) > 0) {
+		return fmt.Errorf("policy %q has no minimum, exact or approved versions", p.Name)
+	}
+	if p.Name == "" {
+		p.Name = p.Model
+	}
+
+	return nil
+}
+
+// Match checks whether the policy applies to the given model and site code.
+func (p *Policy) Match(model, site string) bool {
+	return p.selector.match(model, site)
+}
+
+// Check compares a version against the policy, returning the reason it doesn't comply.
+func (p *Policy) Check(version string) (string, bool) {
+	switch {
+	case version == "":
+		return "no version reported", false
+	case p.Exact != "" && compareVersions(version, p.Exact) != 0:
+		return fmt.Sprintf("expected %s", p.Exact), false
+	case p.Minimum != "" && compareVersions(version, p.Minimum) < 0:
+		return fmt.Sprintf("below minimum %s", p.Minimum), false
+	}
+
+	if len(p.Approved) > 0 {
+		for _, a := range p.Approved {
+			if compareVersions(version, a) == 0 {
+				return "", true
+			}
+		}
+		return fmt.Sprintf("not approved (%s)", strings.Join(p.Approved, ", ")), false
+	}
+
+	return "", true
+}
+
+// Policies is a set of version requirements loaded from a policy file.
+type Policies struct {
+	Policies []*Policy `yaml:"policies"`
+}
+
+func LoadPolicies(path string) (*Policies, error) {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policies
+	if err := yaml.Unmarshal(c, &p); err != nil {
+		return nil, err
+	}
+
+	for _, x := range p.Policies {
+		if err := x.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &p, nil
+}
+
+// Find returns the first policy matching the model and site, if any.
+func (p *Policies) Find(model, site string) *Policy {
+	for _, x := range p.Policies {
+		if x.Match(model, site) {
+			return x
+		}
+	}
+	return nil
+}
+
+// Violation is a device which doesn't comply with its version policy.
+type Violation struct {
+	Site    string
+	Host    string
+	Model   string
+	Key     string
+	Version string
+	Policy  string
+	Reason  string
+}
+
+// Audit checks the stored device states against the policies, also returning
+// the number of devices checked and the number without a matching policy.
+func (p *Policies) Audit(found map[string]*stored, models, sites *regexp.Regexp) ([]Violation, int, int) {
+
+	var list []Violation
+	var checked, unmanaged int
+
+	for f, s := range found {
+		host := strings.TrimSuffix(filepath.Base(f), ".json")
+		site := filepath.Base(filepath.Dir(f))
+		model := s.text("model")
+
+		if models != nil && !models.MatchString(model) {
+			continue
+		}
+		if sites != nil && !sites.MatchString(site) {
+			continue
+		}
+
+		x := p.Find(model, site)
+		if x == nil {
+			unmanaged++
+			continue
+		}
+		checked++
+
+		key, version := x.Key, s.text(x.Key)
+		if key == "" {
+			for _, k := range versionKeys {
+				if v := s.text(k); v != "" {
+					key, version = k, v
+					break
+				}
+			}
+		}
+
+		if reason, ok := x.Check(version); !ok {
+			list = append(list, Violation{
+				Site:    site,
+				Host:    host,
+				Model:   model,
+				Key:     key,
+				Version: version,
+				Policy:  x.Name,
+				Reason:  reason,
+			})
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Site != list[j].Site {
+			return list[i].Site < list[j].Site
+		}
+		return list[i].Host < list[j].Host
+	})
+
+	return list, checked, unmanaged
+}
+
+func auditText(w io.Writer, list []Violation) error {
+	tab := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	var site string
+	for _, v := range list {
+		if v.Site != site {
+			fmt.Fprintf(tab, "%s\n", strings.ToUpper(v.Site))
+			site = v.Site
+		}
+		fmt.Fprintf(tab, "  %s\t%s\t%s %s\t%s\n", v.Host, v.Model, v.Key, v.Version, v.Reason)
+	}
+
+	return tab.Flush()
+}
+
+func auditCSV(w io.Writer, list []Violation) error {
+	c := csv.NewWriter(w)
+	if err := c.Write([]string{"site", "host", "model", "key", "version", "policy", "reason"}); err != nil {
+		return err
+	}
+	for _, v := range list {
+		if err := c.Write([]string{v.Site, v.Host, v.Model, v.Key, v.Version, v.Policy, v.Reason}); err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+func audit(args []string) {
+
+	f := flag.NewFlagSet("audit", flag.ExitOnError)
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Audit stored equipment firmware and software versions against a policy\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s [options] audit [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "General Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Equipment Audit Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		f.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	var policy string
+	f.StringVar(&policy, "policy", "", "yaml file of approved versions per model")
+
+	var models string
+	f.StringVar(&models, "models", ".*", "regex expression to match equipment models")
+
+	var sites string
+	f.StringVar(&sites, "sites", ".*", "regex expression to match equipment site codes")
+
+	var format string
+	f.StringVar(&format, "format", "text", "audit output format (text or csv)")
+
+	var strict bool
+	f.BoolVar(&strict, "strict", false, "exit with an error status if any device doesn't comply")
+
+	if err := f.Parse(args); err != nil {
+		f.Usage()
+
+		log.Fatalf("Invalid option(s) given")
+	}
+
+	if policy == "" {
+		f.Usage()
+
+		log.Fatalf("Missing policy file")
+	}
+
+	p, err := LoadPolicies(policy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	found, err := states(base)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	list, checked, unmanaged := p.Audit(found, regexp.MustCompile(models), regexp.MustCompile(sites))
+
+	claimStdout(os.Stdout)
+
+	switch format {
+	case "csv":
+		err = auditCSV(os.Stdout, list)
+	case "text", "":
+		err = auditText(os.Stdout, list)
+	default:
+		log.Fatalf("Unknown audit format: %s", format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("%d of %d devices do not comply, %d without a policy\n", len(list), checked, unmanaged)
+
+	if strict && len(list) > 0 {
+		os.Exit(1)
+	}
+}
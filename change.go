@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ozym/dmc"
+)
+
+// ChangeType classifies a difference between two device states.
+type ChangeType string
+
+const (
+	ChangeAdded      ChangeType = "added"
+	ChangeRemoved    ChangeType = "removed"
+	ChangeModified   ChangeType = "changed"
+	ChangeSwapped    ChangeType = "hardware swapped"
+	ChangeUpgrade    ChangeType = "upgraded"
+	ChangeDowngrade  ChangeType = "downgraded"
+	ChangeModel      ChangeType = "model changed"
+	ChangeRelocated  ChangeType = "site changed"
+	ChangeLicense    ChangeType = "license changed"
+	ChangeCommission ChangeType = "commissioned"
+)
+
+// Change describes a single field level difference in a device state.
+type Change struct {
+	Device string      `json:"device"`
+	IP     string      `json:"ip"`
+	Key    string      `json:"key"`
+	Type   ChangeType  `json:"type"`
+	Old    interface{} `json:"old,omitempty"`
+	New    interface{} `json:"new,omitempty"`
+}
+
+func (c Change) String() string {
+	switch c.Type {
+	case ChangeAdded:
+		return fmt.Sprintf("%s added = %v", c.Key, c.New)
+	case ChangeRemoved:
+		return fmt.Sprintf("%s removed (was %v)", c.Key, c.Old)
+	default:
+		return fmt.Sprintf("%s %v -> %v = %s", c.Key, c.Old, c.New, c.Type)
+	}
+}
+
+// Level gives the notification severity of the change.
+func (c Change) Level() Level {
+	switch c.Type {
+	case ChangeSwapped, ChangeModel, ChangeRelocated, ChangeDowngrade:
+		return WarningLevel
+	default:
+		return InfoLevel
+	}
+}
+
+// identity values are always compared, whatever their type
+func identity(key string) bool {
+	switch key {
+	case "model", "serial", "site", "code", "firmware", "software", "version", "sysver":
+		return true
+	}
+	return strings.HasPrefix(key, "license")
+}
+
+// the known measurement values, which change on every poll
+var measurements = map[string]bool{
+	"timestamp":   true,
+	"interfaces":  true,
+	"fingerprint": true,
+	"rtt":         true,
+	"packet_loss": true,
+	"uptime":      true,
+}
+
+// volatile measurement values are not considered to be changes, any other numeric
+// values are assumed to be measurements unless they identify the device
+func volatile(key string, value interface{}) bool {
+	switch {
+	case identity(key):
+		return false
+	case measurements[key]:
+		return true
+	}
+	_, ok := number(value)
+	return ok
+}
+
+// number converts any numeric state value, as stored or as restored from json
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// canonical text of a value, numbers are formatted the same way whether or not they have been through json
+func canonical(v interface{}) string {
+	if f, ok := number(v); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// stable removes the measurement values from table rows, so only changes to the rows themselves are found
+func stable(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		var list []interface{}
+		for _, x := range v {
+			list = append(list, stable(x))
+		}
+		return list
+	case map[string]interface{}:
+		row := make(map[string]interface{})
+		for k, x := range v {
+			if !volatile(k, x) {
+				row[k] = stable(x)
+			}
+		}
+		return row
+	default:
+		return value
+	}
+}
+
+func classify(key string, old, now interface{}) ChangeType {
+	switch key {
+	case "serial":
+		return ChangeSwapped
+	case "model":
+		return ChangeModel
+	case "site", "code":
+		return ChangeRelocated
+	case "license":
+		return ChangeLicense
+	case "firmware", "software", "version", "sysver":
+		switch n := compareVersions(canonical(old), canonical(now)); {
+		case n < 0:
+			return ChangeUpgrade
+		case n > 0:
+			return ChangeDowngrade
+		}
+	}
+	return ChangeModified
+}
+
+// Diff compares two device states, returning the identity changes found.
+func Diff(d dmc.Device, old, now *dmc.State) []Change {
+	if old == nil || now == nil {
+		return nil
+	}
+
+	keys := make(map[string]bool)
+	for k := range old.Values {
+		keys[k] = true
+	}
+	for k := range now.Values {
+		keys[k] = true
+	}
+
+	var list []string
+	for k := range keys {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	var changes []Change
+	for _, k := range list {
+		o, okOld := old.Values[k]
+		n, okNew := now.Values[k]
+
+		c := Change{Device: d.Name, IP: d.IP.String(), Key: k, Old: o, New: n}
+
+		switch {
+		case okOld && volatile(k, o), okNew && volatile(k, n):
+			continue
+		case !okOld:
+			c.Type = ChangeAdded
+		case !okNew:
+			c.Type = ChangeRemoved
+		case canonical(stable(o)) == canonical(stable(n)):
+			continue
+		default:
+			c.Type = classify(k, o, n)
+		}
+
+		changes = append(changes, c)
+	}
+
+	return changes
+}
+
+// compareVersions orders two version strings by their numeric and text components.
+func compareVersions(a, b string) int {
+	split := func(s string) []string {
+		return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return strings.ContainsRune(".-_/ ()+:", r)
+		})
+	}
+
+	x, y := split(a), split(b)
+	for i := 0; i < len(x) || i < len(y); i++ {
+		switch {
+		case !(i < len(x)):
+			return -1
+		case !(i < len(y)):
+			return 1
+		}
+
+		n, errA := strconv.ParseFloat(strings.TrimLeft(x[i], "v"), 64)
+		m, errB := strconv.ParseFloat(strings.TrimLeft(y[i], "v"), 64)
+		switch {
+		case errA == nil && errB == nil && n < m:
+			return -1
+		case errA == nil && errB == nil && n > m:
+			return 1
+		case errA == nil && errB == nil:
+		case x[i] < y[i]:
+			return -1
+		case x[i] > y[i]:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// detect compares a new device state against the stored state
+func detect(d dmc.Device, s *dmc.State) ([]Change, error) {
+
+	// couldn't find a model, nothing will be stored ...
+	if _, ok := s.Values["model"]; !ok {
+		return nil, nil
+	}
+
+	p, err := previous(d)
+	if p == nil || err != nil {
+		return nil, err
+	}
+
+	return Diff(d, p, s), nil
+}
+
+// run based change log, only created when the first change is found
+var runlog struct {
+	sync.Mutex
+	start time.Time
+	file  *os.File
+}
+
+func init() {
+	runlog.start = time.Now().UTC()
+}
+
+func logChanges(list []Change) error {
+	if changelog == "" || !(len(list) > 0) {
+		return nil
+	}
+
+	runlog.Lock()
+	defer runlog.Unlock()
+
+	if runlog.file == nil {
+		if err := os.MkdirAll(changelog, 0755); err != nil {
+			return err
+		}
+		name := filepath.Join(changelog, "changes-"+runlog.start.Format("20060102T150405Z")+".log")
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		runlog.file = f
+	}
+
+	for _, c := range list {
+		b, err := json.Marshal(struct {
+			Change
+			Timestamp time.Time `json:"timestamp"`
+		}{c, time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+		if _, err := runlog.file.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flushChanges closes the run based change log, if one was opened
+func flushChanges() error {
+	runlog.Lock()
+	defer runlog.Unlock()
+
+	if runlog.file == nil {
+		return nil
+	}
+
+	f := runlog.file
+	runlog.file = nil
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// announce sends any changes to the change log and the configured notifiers
+func announce(d dmc.Device, list []Change) error {
+	if !(len(list) > 0) {
+		return nil
+	}
+
+	if err := logChanges(list); err != nil {
+		return err
+	}
+
+	level := InfoLevel
+	var lines []string
+	for _, c := range list {
+		if c.Level() > level {
+			level = c.Level()
+		}
+		lines = append(lines, c.String())
+		if verbose {
+			log.Printf("change: %s [%s] %s\n", d.Name, d.IP.String(), c.String())
+		}
+	}
+
+	subject := fmt.Sprintf("%s [%s] state changed", d.Name, d.IP.String())
+
+	return currentNotifiers().Notify(level, subject, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/ozym/dmc"
+)
+
+// restored gives a state as it would be read back from a stored json file
+func restored(t *testing.T, values map[string]interface{}) *dmc.State {
+	s := dmc.State{Values: make(map[string]interface{})}
+	if err := json.Unmarshal((&dmc.State{Values: values}).Marshal(), &s.Values); err != nil {
+		t.Fatal(err)
+	}
+	return &s
+}
+
+func TestDiff(t *testing.T) {
+	d := dmc.Device{Name: "wgtn-q330.wan.geonet.org.nz.", IP: net.ParseIP("192.168.1.10"), Model: "Quanterra Q330"}
+
+	var tests = []struct {
+		name    string
+		old     map[string]interface{}
+		now     map[string]interface{}
+		changes map[string]ChangeType
+	}{
+		{
+			name: "unchanged numeric identity",
+			old:  map[string]interface{}{"model": "Quanterra Q330", "version": uint16(263), "sysver": uint16(1)},
+			now:  map[string]interface{}{"model": "Quanterra Q330", "version": uint16(263), "sysver": uint16(1)},
+		},
+		{
+			name:    "numeric firmware upgrade",
+			old:     map[string]interface{}{"model": "Quanterra Q330", "version": uint16(263)},
+			now:     map[string]interface{}{"model": "Quanterra Q330", "version": uint16(264)},
+			changes: map[string]ChangeType{"version": ChangeUpgrade},
+		},
+		{
+			name:    "numeric firmware downgrade",
+			old:     map[string]interface{}{"sysver": int16(5)},
+			now:     map[string]interface{}{"sysver": int16(4)},
+			changes: map[string]ChangeType{"sysver": ChangeDowngrade},
+		},
+		{
+			name:    "numeric serial swap",
+			old:     map[string]interface{}{"serial": int64(1234)},
+			now:     map[string]interface{}{"serial": int64(5678)},
+			changes: map[string]ChangeType{"serial": ChangeSwapped},
+		},
+		{
+			name:    "text firmware upgrade",
+			old:     map[string]interface{}{"firmware": "6.40.9"},
+			now:     map[string]interface{}{"firmware": "6.41"},
+			changes: map[string]ChangeType{"firmware": ChangeUpgrade},
+		},
+		{
+			name: "measurements ignored",
+			old:  map[string]interface{}{"voltage": 12.1, "uptime": 100, "rtt": 1.5, "timestamp": "a"},
+			now:  map[string]interface{}{"voltage": 11.9, "uptime": 200, "rtt": 2.5, "timestamp": "b"},
+		},
+		{
+			name:    "identity added and removed",
+			old:     map[string]interface{}{"license": "basic"},
+			now:     map[string]interface{}{"serial": uint32(42)},
+			changes: map[string]ChangeType{"license": ChangeRemoved, "serial": ChangeAdded},
+		},
+		{
+			name:    "relocated",
+			old:     map[string]interface{}{"site": "WGTN"},
+			now:     map[string]interface{}{"site": "WEL"},
+			changes: map[string]ChangeType{"site": ChangeRelocated},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the old state has always been stored as json
+			changes := Diff(d, restored(t, tt.old), &dmc.State{Values: tt.now})
+			if len(changes) != len(tt.changes) {
+				t.Fatalf("expected %d changes, got %v", len(tt.changes), changes)
+			}
+			for _, c := range changes {
+				if x, ok := tt.changes[c.Key]; !ok || x != c.Type {
+					t.Errorf("unexpected change %s", c.String())
+				}
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	base = t.TempDir()
+
+	d := dmc.Device{Name: "wgtn-q330.wan.geonet.org.nz.", IP: net.ParseIP("192.168.1.10"), Model: "Quanterra Q330"}
+
+	old := dmc.State{Values: map[string]interface{}{"model": "Quanterra Q330", "version": uint16(263), "serial": "0x0100000000000001"}}
+	if err := store(d, &old); err != nil {
+		t.Fatal(err)
+	}
+
+	now := dmc.State{Values: map[string]interface{}{"model": "Quanterra Q330", "version": uint16(264), "serial": "0x0100000000000001"}}
+	changes, err := detect(d, &now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Key != "version" || changes[0].Type != ChangeUpgrade {
+		t.Errorf("expected a version upgrade, got %v", changes)
+	}
+
+	changes, err = detect(d, &old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	var tests = []struct {
+		a, b string
+		n    int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10", "1.9", 1},
+		{"v2.0", "1.9", 1},
+		{"6.40.9", "6.41", -1},
+		{"1.2", "1.2.1", -1},
+		{"4.93 (2016-01-01)", "4.93 (2016-01-01)", 0},
+		{"263", "264", -1},
+		{"2.0-beta", "2.0-alpha", 1},
+	}
+
+	for _, tt := range tests {
+		if n := compareVersions(tt.a, tt.b); n != tt.n {
+			t.Errorf("compareVersions(%q, %q): expected %d, got %d", tt.a, tt.b, tt.n, n)
+		}
+	}
+}
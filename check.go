@@ -1,20 +1,25 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/paulstuart/ping"
-
 	"github.com/ozym/dmc"
 	"github.com/ozym/zone"
 )
 
+var (
+	fingerprinting = true
+	probeTimeout   = time.Second * 2
+)
+
 func check(args []string) {
 
 	f := flag.NewFlagSet("check", flag.ExitOnError)
@@ -51,6 +56,39 @@ func check(args []string) {
 	var limit int
 	f.IntVar(&limit, "limit", 8, "number of concurrent queries")
 
+	var format string
+	f.StringVar(&format, "format", "", "optional result output format on stdout (jsonl, csv or table)")
+
+	var values string
+	f.StringVar(&values, "values", strings.Join(resultKeys, ","), "comma separated state values included in the result output")
+
+	var deadline time.Duration
+	f.DurationVar(&deadline, "deadline", 0, "optional time limit for the whole run, devices not yet checked are skipped")
+
+	f.BoolVar(&fingerprinting, "fingerprint", true, "probe device services first to narrow down and order the drivers tried")
+	f.DurationVar(&probeTimeout, "probe-timeout", time.Second*2, "timeout used when fingerprinting devices")
+
+	var apply bool
+	f.BoolVar(&apply, "apply", false, "update the dns model of positively identified equipment, keeping the site code")
+
+	var dryrun bool
+	f.BoolVar(&dryrun, "dry-run", false, "only print the dns updates which would be applied")
+
+	var yes bool
+	f.BoolVar(&yes, "yes", false, "apply the dns updates without asking for confirmation")
+
+	var ttl uint
+	f.UintVar(&ttl, "ttl", 86400, "time to live of updated dns entries")
+
+	var key string
+	f.StringVar(&key, "tsig-key", os.Getenv("TSIG_KEY"), "tsig key name used for dns updates")
+
+	var secret string
+	f.StringVar(&secret, "tsig-secret", os.Getenv("TSIG_SECRET"), "tsig key secret used for dns updates")
+
+	var record string
+	f.StringVar(&record, "record", "", "file to append applied dns updates to (defaults to commissioned.jsonl in the base directory)")
+
 	var models string
 	f.StringVar(&models, "models", "^Uninstalled", "device model regexp to check")
 
@@ -63,6 +101,21 @@ func check(args []string) {
 		log.Fatalf("Invalid option(s) given")
 	}
 
+	if apply && !dryrun && (key == "" || secret == "") {
+		log.Fatalf("Missing tsig key or secret needed to apply dns updates")
+	}
+	if record == "" {
+		record = filepath.Join(base, "commissioned.jsonl")
+	}
+
+	out, err := NewOutput(os.Stdout, format, strings.Split(values, ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := interrupt(deadline)
+	defer cancel()
+
 	// concurrent goroutines
 	var wg sync.WaitGroup
 
@@ -89,42 +142,121 @@ func check(args []string) {
 		}
 
 		// wait ...
-		sem <- struct{}{}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
 		wg.Add(1)
 
-		go func(d dmc.Device) {
+		go func(l *zone.Device, d dmc.Device) {
 			defer func() { <-sem; wg.Done() }()
 
-			if ok := ping.Ping(d.IP.String(), (int)(timeout/time.Second)); !ok {
-				if verbose {
-					log.Printf("skipping: %s\n", d.String())
-				}
-				return
+			r, st := discover(ctx, d, timeout, retries)
+			if err := out.Write(NewResult(d, r.Reachable(), ctx.Err() != nil, st, out.Keys())); err != nil {
+				log.Println(err)
 			}
-			if verbose {
-				log.Printf("discover!: %s\n", d.String())
+			if apply {
+				propose(l, st)
+			}
+		}(l, d)
+	}
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		log.Printf("run stopped early: %s\n", err)
+	}
+
+	if err := out.Close(); err != nil {
+		log.Println(err)
+	}
+
+	if apply {
+		svc := zone.Service{Server: master, Key: key, Secret: secret}
+		if err := commission(&svc, lookup, uint32(ttl), dryrun, yes, record); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if err := flushChanges(); err != nil {
+		log.Println(err)
+	}
+}
+
+// discover checks whether a newly installed device is reachable and then tries to identify
+// it against every known model, it returns the probe results and the accepted state if any.
+func discover(ctx context.Context, d dmc.Device, timeout time.Duration, retries int) (*Reachability, *dmc.State) {
+
+	register(d)
+
+	if ctx.Err() != nil {
+		return &Reachability{}, nil
+	}
+
+	r := reachable(ctx, d, timeout)
+	if !r.Reachable() {
+		if verbose {
+			log.Printf("skipping: %s\n", d.String())
+		}
+		return r, nil
+	}
+	if verbose {
+		log.Printf("discover!: %s\n", d.String())
+	}
+
+	models := dmc.ModelList
+
+	// narrow down the likely drivers before trying them in turn
+	var fp *dmc.Fingerprint
+	if fingerprinting {
+		fp = dmc.Probe(ctx, d.IP, probeTimeout)
+		models = fp.Order(models)
+		if verbose {
+			var list []string
+			for _, m := range models {
+				list = append(list, m.Name())
 			}
+			log.Printf("fingerprint: %s %v -> %s\n", d.String(), fp.Values(), strings.Join(list, ", "))
+		}
+	}
+
+	accept := func(s *dmc.State) bool {
+		if fp != nil {
+			s.Values["fingerprint"] = fp.Values()
+		}
+		r.Values(s)
+		return device(d, s)
+	}
+
+	for _, m := range models {
+		if ctx.Err() != nil {
+			break
+		}
 
-			for _, m := range dmc.ModelList {
+		if verbose {
+			log.Printf("\tcheck against: %s\n", m.Name())
+		}
 
-				if verbose {
-					log.Printf("\tcheck against: %s\n", m.Name())
-				}
+		if s, _ := d.IdentifyContext(ctx, m, d.Model, timeout, retries); s != nil {
+			if accept(s) {
+				return r, s
+			}
+		}
 
-				if s, _ := d.Identify(m, d.Model, timeout, retries); s != nil {
-					if device(d, s) {
-						return
-					}
-				}
+		// the fingerprint has already ordered every plausible driver
+		if fp != nil {
+			continue
+		}
 
-				if s := d.Discover(m, d.Model, timeout, retries); s != nil {
-					if device(d, s) {
-						return
-					}
-				}
+		if s := d.DiscoverContext(ctx, m, d.Model, timeout, retries); s != nil {
+			if accept(s) {
+				return r, s
 			}
-		}(d)
+		}
 	}
 
-	wg.Wait()
+	return r, nil
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/ozym/dmc"
+	"github.com/ozym/zone"
+)
+
+// Commission is a DNS model update for a newly identified device.
+type Commission struct {
+	From *zone.Device
+	To   *zone.Device
+}
+
+func (c Commission) Change() Change {
+	return Change{
+		Device: c.From.Name,
+		IP:     c.From.IP.String(),
+		Key:    "hinfo",
+		Type:   ChangeCommission,
+		Old:    c.From.Model,
+		New:    c.To.Model,
+	}
+}
+
+func (c Commission) String() string {
+	return fmt.Sprintf("%s [%s] %q -> %q (%s)", c.From.Name, c.From.IP.String(), c.From.Model, c.To.Model, c.To.Code)
+}
+
+// identified devices waiting to be promoted in DNS
+var commissions struct {
+	sync.Mutex
+	list []Commission
+}
+
+// propose queues a DNS update for a device which has been positively identified, the site code is kept
+func propose(l *zone.Device, s *dmc.State) {
+	if s == nil {
+		return
+	}
+
+	model, ok := s.Values["model"].(string)
+	if !ok || model == "" || model == l.Model || strings.HasPrefix(model, "Uninstalled") {
+		return
+	}
+
+	to := *l
+	to.Model = model
+
+	commissions.Lock()
+	defer commissions.Unlock()
+
+	commissions.list = append(commissions.list, Commission{From: l, To: &to})
+}
+
+// confirm asks for a yes or no answer
+func confirm(r io.Reader, w io.Writer, question string) bool {
+	fmt.Fprintf(w, "%s [y/N] ", question)
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && line == "" {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// recordCommission appends an applied update to the commissioning record
+func recordCommission(path string, c Commission) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(struct {
+		Change
+		Code      string    `json:"code"`
+		Timestamp time.Time `json:"timestamp"`
+	}{c.Change(), c.To.Code, time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// updateModel replaces the HINFO record of a device with a single signed dynamic update (RFC 2136),
+// the existing record is a prerequisite so nothing is changed if dns has been edited since it was read
+func updateModel(svc *zone.Service, domain string, ttl uint32, from, to *zone.Device) error {
+
+	old := from.ToHINFO()
+
+	rr := to.ToHINFO()
+	rr.Hdr.Ttl = ttl
+
+	m := new(dns.Msg)
+	m.SetUpdate(domain)
+	m.Used([]dns.RR{old})
+	m.Ns = []dns.RR{
+		&dns.ANY{Hdr: dns.RR_Header{Name: rr.Hdr.Name, Rrtype: dns.TypeHINFO, Class: dns.ClassANY}},
+		rr,
+	}
+	// the uncompressed length estimate of HINFO records is too short in this version of the dns package
+	m.Compress = true
+	m.SetTsig(dns.Fqdn(svc.Key), dns.HmacMD5, 300, time.Now().Unix())
+
+	h, err := svc.ServerPort()
+	if err != nil {
+		return err
+	}
+
+	c := new(dns.Client)
+	c.TsigSecret = map[string]string{dns.Fqdn(svc.Key): svc.Secret}
+
+	r, _, err := c.Exchange(m, h)
+	if err != nil {
+		return err
+	}
+
+	switch r.Rcode {
+	case dns.RcodeSuccess:
+		return nil
+	case dns.RcodeNXRrset:
+		return fmt.Errorf("existing dns entry has changed for %s", to.Name)
+	default:
+		return fmt.Errorf("invalid update answer for %s: %s", to.Name, dns.RcodeToString[r.Rcode])
+	}
+}
+
+// commission applies the queued model updates, only printing them for a dry run
+func commission(svc *zone.Service, domain string, ttl uint32, dryrun, yes bool, record string) error {
+
+	commissions.Lock()
+	list := commissions.list
+	commissions.list = nil
+	commissions.Unlock()
+
+	if !(len(list) > 0) {
+		return nil
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].From.Name < list[j].From.Name
+	})
+
+	for _, c := range list {
+		fmt.Fprintf(os.Stderr, "commission: %s\n", c.String())
+	}
+
+	if dryrun {
+		return nil
+	}
+
+	if !yes && !confirm(os.Stdin, os.Stderr, fmt.Sprintf("update %d dns entries in %s?", len(list), domain)) {
+		return fmt.Errorf("dns updates not confirmed")
+	}
+
+	var failed int
+	for _, c := range list {
+		if err := updateModel(svc, domain, ttl, c.From, c.To); err != nil {
+			log.Printf("commission failed: %s: %s\n", c.From.Name, err)
+			failed++
+			continue
+		}
+
+		if err := recordCommission(record, c); err != nil {
+			return err
+		}
+
+		d := dmc.Device{Name: c.From.Name, IP: c.From.IP, Model: c.To.Model}
+		if err := announce(d, []Change{c.Change()}); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dns updates failed", failed, len(list))
+	}
+
+	return nil
+}
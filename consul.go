@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/ozym/dmc"
+)
+
+// Consul health check states.
+const (
+	CheckPassing  = "passing"
+	CheckWarning  = "warning"
+	CheckCritical = "critical"
+)
+
+// prefix of the check ids managed by the equipment polling
+const checkPrefix = "equipment:"
+
+// how often the services registered by load are re-read
+const registrationRefresh = 10 * time.Minute
+
+// Checks pushes polling results into consul as health checks on the equipment services registered by load.
+// The equipment are external nodes, and agent TTL checks can only be attached to the agent's own node, so
+// these are catalog checks instead. The output starts with the poll time and checks which are not refreshed
+// are marked as stale using Expire, both the daemon and status commands do this.
+type Checks struct {
+	catalog  *api.Catalog
+	health   *api.Health
+	settings *Catalog
+
+	mu       sync.Mutex
+	last     map[string]string
+	services map[string]bool
+	loaded   time.Time
+}
+
+// NewChecks connects to the consul server, the service settings should match those used by load.
+func NewChecks(address string, settings *Catalog) (*Checks, error) {
+	config := api.DefaultConfig()
+	if address != "" {
+		config.Address = address
+	}
+
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Checks{catalog: client.Catalog(), health: client.Health(), settings: settings, last: make(map[string]string)}, nil
+}
+
+// checkSettings loads the consul service settings, falling back to the load defaults
+func checkSettings(path string) (*Catalog, error) {
+	if path != "" {
+		return LoadCatalog(path)
+	}
+	settings := defaultCatalog
+	if err := settings.compile(); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// checkStatus decides the health of a device, any active threshold alerts downgrade an identified device
+func checkStatus(d dmc.Device, reachable bool, s *dmc.State) string {
+	switch {
+	case !reachable:
+		return CheckCritical
+	case s == nil:
+		return CheckWarning
+	}
+
+	level := InfoLevel
+	if f := alertFile(d); f != "" {
+		alerts, err := loadAlerts(f)
+		if err != nil {
+			log.Println(err)
+		}
+		for _, l := range alerts {
+			if l > level {
+				level = l
+			}
+		}
+	}
+
+	switch level {
+	case CriticalLevel:
+		return CheckCritical
+	case WarningLevel:
+		return CheckWarning
+	default:
+		return CheckPassing
+	}
+}
+
+// checkOutput summarises the device state for the check output
+func checkOutput(reachable bool, s *dmc.State) string {
+	switch {
+	case !reachable:
+		return "device is unreachable"
+	case s == nil:
+		return "device is reachable but could not be identified"
+	}
+
+	var parts []string
+	if m, ok := s.Values["model"]; ok {
+		parts = append(parts, fmt.Sprintf("model=%v", m))
+	}
+	for _, k := range resultKeys {
+		if v, ok := s.Values[k]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%v", k, v))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// register stores the equipment check of a device service in the catalog
+func (c *Checks) register(node, service, status, output string) error {
+	address := node
+	if c.settings.Domain != "" {
+		address = node + "." + c.settings.Domain
+	}
+
+	r := api.CatalogRegistration{
+		Node:       node,
+		Address:    address,
+		Datacenter: c.settings.Datacenter,
+		Check: &api.AgentCheck{
+			Node:        node,
+			CheckID:     checkPrefix + service,
+			Name:        "equipment " + service,
+			Status:      status,
+			Notes:       "updated by equipment status polling",
+			Output:      output,
+			ServiceID:   service,
+			ServiceName: service,
+		},
+	}
+
+	_, err := c.catalog.Register(&r, &api.WriteOptions{Datacenter: c.settings.Datacenter})
+
+	return err
+}
+
+// registered checks whether load has registered the service for the node, consul rejects checks of unknown services
+func (c *Checks) registered(node, service string) (bool, error) {
+	c.mu.Lock()
+	services, loaded := c.services, c.loaded
+	c.mu.Unlock()
+
+	if services == nil || time.Since(loaded) > registrationRefresh {
+		services = make(map[string]bool)
+		for _, s := range c.settings.Managed() {
+			list, _, err := c.catalog.Service(s, "", &api.QueryOptions{Datacenter: c.settings.Datacenter})
+			if err != nil {
+				return false, err
+			}
+			for _, x := range list {
+				services[x.Node+"/"+x.ServiceID] = true
+			}
+		}
+
+		c.mu.Lock()
+		c.services, c.loaded = services, time.Now()
+		c.mu.Unlock()
+	}
+
+	return services[node+"/"+service], nil
+}
+
+// Update sets the health check of the device service, devices without a service mapping or
+// which haven't been registered by load, such as those without a site code, are ignored.
+func (c *Checks) Update(d dmc.Device, reachable bool, s *dmc.State) error {
+	if c == nil {
+		return nil
+	}
+
+	m := c.settings.Find(d.Model)
+	if m == nil {
+		return nil
+	}
+
+	node, _ := names(d)
+	if node == "" {
+		return nil
+	}
+
+	ok, err := c.registered(node, m.Service)
+	if err != nil || !ok {
+		return err
+	}
+
+	status := checkStatus(d, reachable, s)
+	output := "polled=" + time.Now().UTC().Format(time.RFC3339) + " " + checkOutput(reachable, s)
+
+	if err := c.register(node, m.Service, status, output); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if verbose && c.last[d.Name] != status {
+		log.Printf("consul check: %s %s -> %s\n", node, m.Service, status)
+	}
+	c.last[d.Name] = status
+
+	return nil
+}
+
+// polled recovers the poll time from the output of an equipment check
+func polled(output string) (time.Time, bool) {
+	f := strings.Fields(output)
+	if !(len(f) > 0) || !strings.HasPrefix(f[0], "polled=") {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimPrefix(f[0], "polled="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Expire marks any equipment checks not polled within the given age as critical, catalog checks
+// are never expired by consul itself. The original poll time is kept in the output so that an
+// external process can also find and remove checks of devices which are no longer polled.
+func (c *Checks) Expire(age time.Duration, now time.Time) (int, error) {
+	if c == nil || !(age > 0) {
+		return 0, nil
+	}
+
+	var count int
+
+	seen := make(map[string]bool)
+	for _, m := range c.settings.Services {
+		if seen[m.Service] {
+			continue
+		}
+		seen[m.Service] = true
+
+		checks, _, err := c.health.Checks(m.Service, &api.QueryOptions{Datacenter: c.settings.Datacenter})
+		if err != nil {
+			return count, err
+		}
+		for _, x := range checks {
+			if x.CheckID != checkPrefix+m.Service || strings.HasPrefix(x.Output, "stale ") {
+				continue
+			}
+			t, ok := polled(x.Output)
+			if !ok || !(now.Sub(t) > age) {
+				continue
+			}
+			if err := c.register(x.Node, m.Service, CheckCritical, "stale "+x.Output); err != nil {
+				return count, err
+			}
+			if verbose {
+				log.Printf("consul check: %s %s is stale, last polled %s\n", x.Node, m.Service, t.Format(time.RFC3339))
+			}
+			count++
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/ozym/dmc"
+)
+
+// fakeConsul records catalog registrations and serves them back as health checks
+type fakeConsul struct {
+	mu       sync.Mutex
+	services []*api.CatalogService
+	checks   map[string]*api.AgentCheck
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == "PUT" && r.URL.Path == "/v1/catalog/register":
+		var reg api.CatalogRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil || reg.Check == nil || reg.Address == "" {
+			http.Error(w, "invalid registration", http.StatusBadRequest)
+			return
+		}
+		f.checks[reg.Node+"/"+reg.Check.CheckID] = reg.Check
+		w.Write([]byte("true"))
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/v1/health/checks/"):
+		service := strings.TrimPrefix(r.URL.Path, "/v1/health/checks/")
+		list := []*api.HealthCheck{}
+		for _, c := range f.checks {
+			if c.ServiceName == service {
+				h := api.HealthCheck(*c)
+				list = append(list, &h)
+			}
+		}
+		json.NewEncoder(w).Encode(list)
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/v1/catalog/service/"):
+		service := strings.TrimPrefix(r.URL.Path, "/v1/catalog/service/")
+		list := []*api.CatalogService{}
+		for _, s := range f.services {
+			if s.ServiceName == service {
+				list = append(list, s)
+			}
+		}
+		json.NewEncoder(w).Encode(list)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeConsul) check(id string) *api.AgentCheck {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.checks[id]
+}
+
+func TestChecks(t *testing.T) {
+	base = t.TempDir()
+
+	fake := fakeConsul{
+		services: []*api.CatalogService{{Node: "aaa-wgtn", ServiceID: "qdp", ServiceName: "qdp"}},
+		checks:   make(map[string]*api.AgentCheck),
+	}
+	server := httptest.NewServer(&fake)
+	defer server.Close()
+
+	settings, err := checkSettings("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks, err := NewChecks(strings.TrimPrefix(server.URL, "http://"), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := dmc.Device{Name: "aaa-wgtn.wan.geonet.org.nz.", IP: net.ParseIP("192.168.1.1"), Model: "Quanterra Q330"}
+
+	var tests = []struct {
+		name      string
+		reachable bool
+		state     *dmc.State
+		status    string
+		output    string
+	}{
+		{"unreachable", false, nil, CheckCritical, "device is unreachable"},
+		{"unidentified", true, nil, CheckWarning, "could not be identified"},
+		{"identified", true, &dmc.State{Values: map[string]interface{}{"model": "Quanterra Q330"}}, CheckPassing, "model=Quanterra Q330"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checks.Update(d, tt.reachable, tt.state); err != nil {
+				t.Fatal(err)
+			}
+			c := fake.check("aaa-wgtn/equipment:qdp")
+			if c == nil {
+				t.Fatal("check not registered")
+			}
+			if c.Status != tt.status {
+				t.Errorf("expected status %q, got %q", tt.status, c.Status)
+			}
+			if _, ok := polled(c.Output); !ok {
+				t.Errorf("missing poll time in output %q", c.Output)
+			}
+			if !strings.Contains(c.Output, tt.output) {
+				t.Errorf("expected output to contain %q, got %q", tt.output, c.Output)
+			}
+		})
+	}
+
+	// devices without a service mapping are ignored
+	if err := checks.Update(dmc.Device{Name: "bbb-wgtn.wan.geonet.org.nz.", Model: "Unknown"}, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c := fake.check("bbb-wgtn/equipment:qdp"); c != nil {
+		t.Errorf("unexpected check for unmapped device: %v", c)
+	}
+
+	// devices which load has not registered are ignored
+	if err := checks.Update(dmc.Device{Name: "ccc-wgtn.wan.geonet.org.nz.", Model: "Quanterra Q330"}, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c := fake.check("ccc-wgtn/equipment:qdp"); c != nil {
+		t.Errorf("unexpected check for unregistered device: %v", c)
+	}
+
+	// recently polled checks are left alone
+	if n, err := checks.Expire(time.Hour, time.Now()); err != nil || n != 0 {
+		t.Fatalf("expected no stale checks, got %d (%v)", n, err)
+	}
+
+	// old checks are marked as stale, but only once
+	for _, n := range []int{1, 0} {
+		count, err := checks.Expire(time.Hour, time.Now().Add(2*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != n {
+			t.Errorf("expected %d stale checks, got %d", n, count)
+		}
+	}
+
+	c := fake.check("aaa-wgtn/equipment:qdp")
+	if c.Status != CheckCritical || !strings.HasPrefix(c.Output, "stale polled=") {
+		t.Errorf("expected a stale critical check, got %q %q", c.Status, c.Output)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ozym/dmc"
+)
+
+// marks the start of an encrypted credentials file, the encoded data is the key salt, the nonce and then the sealed text
+const sealed = "$EQUIPMENT;AES256-GCM;PBKDF2-SHA256\n"
+
+// earlier files were encrypted using a plain sha256 of the key without a salt, these can still be decrypted
+const unsalted = "$EQUIPMENT;AES256-GCM\n"
+
+// key derivation settings for encrypted credentials files
+const (
+	saltSize  = 16
+	keyRounds = 100000
+)
+
+// the environment variable holding the credentials file encryption key
+const credentialsKey = "EQUIPMENT_CREDENTIALS_KEY"
+
+// Access is a single credentials file entry, any given match fields must all
+// apply to a device. The first non-blank value of each credential found in
+// file order is used, so specific entries should be listed before defaults.
+type Access struct {
+	Model  string `yaml:"model"`
+	Site   string `yaml:"site"`
+	Host   string `yaml:"host"`
+	Subnet string `yaml:"subnet"`
+
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+	Community string `yaml:"community"`
+
+	SNMP dmc.Security `yaml:"snmp"`
+
+	selector selector
+	host     *regexp.Regexp
+	subnet   *net.IPNet
+}
+
+func (a *Access) compile() error {
+	var err error
+
+	if a.selector, err = newSelector(a.Model, a.Site); err != nil {
+		return err
+	}
+	if a.Host != "" {
+		if a.host, err = regexp.Compile("^(?i:" + a.Host + ")$"); err != nil {
+			return err
+		}
+	}
+	if a.Subnet != "" {
+		if _, a.subnet, err = net.ParseCIDR(a.Subnet); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Match checks the entry against either the driver or inventory model name, the
+// site code, the host name and the device address.
+func (a *Access) Match(model, expected, site, host string, ip net.IP) bool {
+	if !a.selector.match(model, site) && !a.selector.match(expected, site) {
+		return false
+	}
+	if a.host != nil && !a.host.MatchString(host) {
+		return false
+	}
+	if a.subnet != nil && !a.subnet.Contains(ip) {
+		return false
+	}
+	return true
+}
+
+// Vault resolves device credentials from a credentials file.
+type Vault struct {
+	Access []*Access
+
+	mu      sync.RWMutex
+	devices map[string]dmc.Device
+}
+
+// Register remembers the inventory details of a device so its address can be mapped to a host and site.
+func (v *Vault) Register(d dmc.Device) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.devices[d.IP.String()] = d
+}
+
+// Lookup implements the dmc.Credentials hook.
+func (v *Vault) Lookup(model string, ip net.IP) *dmc.Credential {
+	v.mu.RLock()
+	d, ok := v.devices[ip.String()]
+	v.mu.RUnlock()
+
+	if !ok {
+		d = dmc.Device{IP: ip}
+	}
+
+	host, site := names(d)
+
+	var c dmc.Credential
+
+	first := func(a, b string) string {
+		if a != "" {
+			return a
+		}
+		return b
+	}
+
+	for _, a := range v.Access {
+		if !a.Match(model, d.Model, site, host, ip) {
+			continue
+		}
+		c.Username = first(c.Username, a.Username)
+		c.Password = first(c.Password, a.Password)
+		c.Community = first(c.Community, a.Community)
+		c.Security.Username = first(c.Security.Username, a.SNMP.Username)
+		c.Security.AuthProtocol = first(c.Security.AuthProtocol, a.SNMP.AuthProtocol)
+		c.Security.AuthPassphrase = first(c.Security.AuthPassphrase, a.SNMP.AuthPassphrase)
+		c.Security.PrivProtocol = first(c.Security.PrivProtocol, a.SNMP.PrivProtocol)
+		c.Security.PrivPassphrase = first(c.Security.PrivPassphrase, a.SNMP.PrivPassphrase)
+		c.Security.Fallback = first(c.Security.Fallback, a.SNMP.Fallback)
+	}
+
+	return &c
+}
+
+// pbkdf2 derives a key using hmac sha256 as described in RFC 8018
+func pbkdf2(password, salt []byte, rounds, size int) []byte {
+	prf := hmac.New(sha256.New, password)
+
+	var key []byte
+	for n := uint32(1); len(key) < size; n++ {
+		var count [4]byte
+		binary.BigEndian.PutUint32(count[:], n)
+
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(count[:])
+		u := prf.Sum(nil)
+
+		t := append([]byte(nil), u...)
+		for i := 1; i < rounds; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+
+	return key[:size]
+}
+
+// cipherKey builds the cipher from the environment key, a nil salt gives the older unsalted key
+func cipherKey(salt []byte) (cipher.AEAD, error) {
+	key := os.Getenv(credentialsKey)
+	if key == "" {
+		return nil, fmt.Errorf("missing credentials key, %s is not set", credentialsKey)
+	}
+
+	var derived []byte
+	switch {
+	case salt != nil:
+		derived = pbkdf2([]byte(key), salt, keyRounds, 32)
+	default:
+		sum := sha256.Sum256([]byte(key))
+		derived = sum[:]
+	}
+
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Sealed checks whether the credentials have been encrypted.
+func Sealed(c []byte) bool {
+	return bytes.HasPrefix(c, []byte(sealed)) || bytes.HasPrefix(c, []byte(unsalted))
+}
+
+// Seal encrypts the credentials using the key from the environment and a random salt.
+func Seal(plain []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipherKey(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	data := gcm.Seal(append(salt, nonce...), nonce, plain, nil)
+
+	return []byte(sealed + base64.StdEncoding.EncodeToString(data) + "\n"), nil
+}
+
+// Unseal decrypts the credentials if needed, plain files are returned as is.
+func Unseal(c []byte) ([]byte, error) {
+	var header string
+	var salt []byte
+
+	switch {
+	case bytes.HasPrefix(c, []byte(sealed)):
+		header = sealed
+	case bytes.HasPrefix(c, []byte(unsalted)):
+		header = unsalted
+	default:
+		return c, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(c[len(header):])))
+	if err != nil {
+		return nil, err
+	}
+
+	if header == sealed {
+		if len(data) < saltSize {
+			return nil, fmt.Errorf("invalid encrypted credentials")
+		}
+		salt, data = data[:saltSize], data[saltSize:]
+	}
+
+	gcm, err := cipherKey(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted credentials")
+	}
+
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+// LoadVault reads a possibly encrypted yaml credentials file.
+func LoadVault(path string) (*Vault, error) {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := Unseal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	v := Vault{devices: make(map[string]dmc.Device)}
+	if err := yaml.Unmarshal(plain, &v.Access); err != nil {
+		return nil, err
+	}
+
+	for _, a := range v.Access {
+		if err := a.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &v, nil
+}
+
+// remember the inventory details of a device when using a credentials file
+func register(d dmc.Device) {
+	if vault != nil {
+		vault.Register(d)
+	}
+}
+
+func credentials(args []string) {
+
+	f := flag.NewFlagSet("credentials", flag.ExitOnError)
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Encrypt or decrypt a credentials file using the %s environment variable\n", credentialsKey)
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s [options] credentials [options] encrypt|decrypt <file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "General Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Equipment Credentials Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		f.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	var output string
+	f.StringVar(&output, "output", "", "output file, defaults to stdout")
+
+	if err := f.Parse(args); err != nil {
+		f.Usage()
+
+		log.Fatalf("Invalid option(s) given")
+	}
+
+	if len(f.Args()) != 2 {
+		f.Usage()
+
+		log.Fatalf("Missing action or file name")
+	}
+
+	c, err := ioutil.ReadFile(f.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var res []byte
+	switch f.Arg(0) {
+	case "encrypt":
+		if Sealed(c) {
+			log.Fatalf("File is already encrypted: %s", f.Arg(1))
+		}
+		res, err = Seal(c)
+	case "decrypt":
+		res, err = Unseal(c)
+	default:
+		f.Usage()
+
+		log.Fatalf("Unknown action: %s", f.Arg(0))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch output {
+	case "", "-":
+		os.Stdout.Write(res)
+	default:
+		if err := ioutil.WriteFile(output, res, 0600); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ozym/dmc"
+	"github.com/ozym/zone"
+)
+
+// Schedule gives the polling interval for devices matching a model expression.
+type Schedule struct {
+	Model    string `yaml:"model"`
+	Interval string `yaml:"interval"`
+
+	model    *regexp.Regexp
+	interval time.Duration
+}
+
+// DaemonConfig holds the reloadable daemon settings.
+type DaemonConfig struct {
+	Master      string     `yaml:"master"`
+	Zone        string     `yaml:"zone"`
+	Models      string     `yaml:"models"`
+	Sites       string     `yaml:"sites"`
+	Uninstalled string     `yaml:"uninstalled"`
+	Reload      string     `yaml:"reload"`
+	Interval    string     `yaml:"interval"`
+	Check       string     `yaml:"check"`
+	Backoff     string     `yaml:"backoff"`
+	Jitter      float64    `yaml:"jitter"`
+	Timeout     string     `yaml:"timeout"`
+	Retries     int        `yaml:"retries"`
+	Limit       int        `yaml:"limit"`
+	Rules       string     `yaml:"rules"`
+	Stale       string     `yaml:"stale"`
+	Schedules   []Schedule `yaml:"schedules"`
+
+	models      *regexp.Regexp
+	sites       *regexp.Regexp
+	uninstalled *regexp.Regexp
+	reload      time.Duration
+	interval    time.Duration
+	check       time.Duration
+	backoff     time.Duration
+	timeout     time.Duration
+	stale       time.Duration
+	rules       *Rules
+}
+
+func duration(s string, d time.Duration) (time.Duration, error) {
+	if s == "" {
+		return d, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func (c *DaemonConfig) compile() error {
+	var err error
+
+	if c.models, err = regexp.Compile(c.Models); err != nil {
+		return err
+	}
+	if c.sites, err = regexp.Compile(c.Sites); err != nil {
+		return err
+	}
+	if c.uninstalled, err = regexp.Compile(c.Uninstalled); err != nil {
+		return err
+	}
+	if c.reload, err = duration(c.Reload, time.Hour); err != nil {
+		return err
+	}
+	if c.interval, err = duration(c.Interval, time.Minute*10); err != nil {
+		return err
+	}
+	if c.check, err = duration(c.Check, time.Hour); err != nil {
+		return err
+	}
+	if c.backoff, err = duration(c.Backoff, time.Hour*6); err != nil {
+		return err
+	}
+	if c.timeout, err = duration(c.Timeout, time.Second*10); err != nil {
+		return err
+	}
+	// unreachable devices are still polled at the backoff interval
+	if c.stale, err = duration(c.Stale, 2*c.backoff); err != nil {
+		return err
+	}
+	if !(c.Limit > 0) {
+		c.Limit = 1
+	}
+	for i := range c.Schedules {
+		s := &c.Schedules[i]
+		if s.model, err = regexp.Compile(s.Model); err != nil {
+			return err
+		}
+		if s.interval, err = duration(s.Interval, c.interval); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// period gives the polling interval for a device based on its model
+func (c *DaemonConfig) period(model string) time.Duration {
+	if c.uninstalled.MatchString(model) {
+		return c.check
+	}
+	for _, s := range c.Schedules {
+		if s.model.MatchString(model) {
+			return s.interval
+		}
+	}
+	return c.interval
+}
+
+// jitter spreads a period by a random fraction either side
+func (c *DaemonConfig) jitter(d time.Duration) time.Duration {
+	if !(c.Jitter > 0) || !(d > 0) {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2.0-1.0)*c.Jitter*float64(d))
+}
+
+// task tracks the polling schedule of a single device.
+type task struct {
+	device   *zone.Device
+	next     time.Time
+	failures uint
+	running  bool
+}
+
+// result of polling a task
+type outcome struct {
+	name      string
+	reachable bool
+	state     *dmc.State
+}
+
+// Scheduler polls each device at its own interval, backing off unreachable devices.
+type Scheduler struct {
+	config   *DaemonConfig
+	tasks    map[string]*task
+	exporter *Exporter
+	checks   *Checks
+}
+
+// load refreshes the inventory, keeping the schedule of known devices
+func (s *Scheduler) load() error {
+	c := s.config
+
+	details, err := zone.LoadLocal(c.Master, []string{c.Zone}, []string{})
+	if err != nil {
+		return err
+	}
+
+	tasks := make(map[string]*task)
+	for _, l := range details.List {
+		if !c.models.MatchString(l.Model) && !c.uninstalled.MatchString(l.Model) {
+			continue
+		}
+		if !c.sites.MatchString(l.Name) {
+			continue
+		}
+
+		t, ok := s.tasks[l.Name]
+		if !ok {
+			// spread the initial polling of new devices
+			t = &task{next: time.Now().Add(time.Duration(rand.Int63n(int64(c.period(l.Model)/10 + 1))))}
+		}
+		t.device = l
+		tasks[l.Name] = t
+	}
+
+	if verbose {
+		log.Printf("loaded %d devices\n", len(tasks))
+	}
+
+	s.tasks = tasks
+
+	if s.exporter != nil {
+		s.exporter.Retain(details.List)
+	}
+
+	return nil
+}
+
+// run polls a single device, uninstalled devices are checked against every model
+func (s *Scheduler) run(ctx context.Context, l *zone.Device, c *DaemonConfig) outcome {
+
+	o := outcome{name: l.Name}
+
+	d := dmc.Device{
+		Name:  l.Name,
+		IP:    l.IP,
+		Model: l.Model,
+	}
+
+	var r *Reachability
+	switch {
+	case c.uninstalled.MatchString(l.Model):
+		d.Model = strings.TrimSpace(c.uninstalled.ReplaceAllString(l.Model, ""))
+		r, o.state = discover(ctx, d, c.timeout, c.Retries)
+	default:
+		r, o.state = inspect(ctx, d, c.timeout, c.Retries)
+	}
+	o.reachable = r.Reachable()
+
+	if s.exporter != nil {
+		s.exporter.Update(l, o.reachable, o.state)
+	}
+
+	// an interrupted poll says nothing about the device
+	if ctx.Err() == nil {
+		if err := s.checks.Update(dmc.Device{Name: l.Name, IP: l.IP, Model: l.Model}, o.reachable, o.state); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return o
+}
+
+// reschedule works out when a device should next be polled
+func (s *Scheduler) reschedule(t *task, o outcome) {
+	c := s.config
+
+	period := c.period(t.device.Model)
+
+	switch {
+	case o.reachable:
+		t.failures = 0
+	default:
+		if t.failures < 16 {
+			t.failures++
+		}
+		// never poll a failing device more often than it would be polled anyway
+		limit := c.backoff
+		if period > limit {
+			limit = period
+		}
+		period = period << t.failures
+		if period > limit || !(period > 0) {
+			period = limit
+		}
+	}
+
+	t.next = time.Now().Add(c.jitter(period))
+	t.running = false
+
+	if verbose {
+		log.Printf("next poll: %s at %s\n", t.device.Name, t.next.Format(time.RFC3339))
+	}
+}
+
+func loadDaemonConfig(path string, c DaemonConfig) (*DaemonConfig, error) {
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(b, &c); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.compile(); err != nil {
+		return nil, err
+	}
+	if c.Rules != "" {
+		r, err := LoadRules(c.Rules)
+		if err != nil {
+			return nil, err
+		}
+		c.rules = r
+	}
+
+	return &c, nil
+}
+
+func daemon(args []string) {
+
+	f := flag.NewFlagSet("daemon", flag.ExitOnError)
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Continuously check and monitor equipment on a per device schedule\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s [options] daemon [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "General Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Equipment Daemon Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		f.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "The yaml config file may override any of the options and add per model \"schedules\",\n")
+		fmt.Fprintf(os.Stderr, "it is re-read when a HUP signal is received, notification backends are also rebuilt and\n")
+		fmt.Fprintf(os.Stderr, "any notification files reopened, which allows them to be rotated.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	var def DaemonConfig
+
+	var config string
+	f.StringVar(&config, "config", "", "optional yaml daemon config file")
+
+	f.StringVar(&def.Master, "master", "rhubarb.geonet.org.nz.", "default master for equipment service lookup")
+	f.StringVar(&def.Zone, "zone", "wan.geonet.org.nz.", "default zone for equipment service lookup")
+	f.StringVar(&def.Timeout, "timeout", "10s", "provide a service timeout")
+	f.IntVar(&def.Retries, "retries", 3, "provide a service retry")
+	f.IntVar(&def.Limit, "limit", 8, "number of concurrent queries")
+	f.StringVar(&def.Models, "models", ".*", "regex expression to match equipment models")
+	f.StringVar(&def.Sites, "sites", ".*", "regex expression to match equipment sites")
+	f.StringVar(&def.Uninstalled, "uninstalled", "^Uninstalled", "regex expression to match newly installed equipment models")
+	f.StringVar(&def.Reload, "reload", "1h", "how often to reload the equipment inventory")
+	f.StringVar(&def.Interval, "interval", "10m", "default polling interval for installed equipment")
+	f.StringVar(&def.Check, "check", "1h", "polling interval for newly installed equipment")
+	f.StringVar(&def.Backoff, "backoff", "6h", "maximum polling interval for unreachable equipment")
+	f.Float64Var(&def.Jitter, "jitter", 0.1, "random fraction to spread polling intervals")
+	f.StringVar(&def.Rules, "rules", "", "yaml file of state of health alert thresholds")
+
+	var listen string
+	f.StringVar(&listen, "listen", "", "optional address to serve prometheus metrics on")
+
+	var consul string
+	f.StringVar(&consul, "consul", "", "optional consul server to push device health checks into")
+
+	var services string
+	f.StringVar(&services, "consul-services", "", "yaml file of consul service mappings, as used by load")
+	f.StringVar(&def.Stale, "consul-stale", "", "age at which consul checks are marked as stale, defaults to twice the backoff")
+
+	if err := f.Parse(args); err != nil {
+		f.Usage()
+
+		log.Fatalf("Invalid option(s) given")
+	}
+
+	rand.Seed(time.Now().UnixNano())
+
+	c, err := loadDaemonConfig(config, def)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	setThresholds(c.rules)
+
+	s := Scheduler{config: c, tasks: make(map[string]*task)}
+
+	if listen != "" {
+		s.exporter = NewExporter()
+		http.Handle("/metrics", s.exporter)
+		go func() {
+			log.Fatal(http.ListenAndServe(listen, nil))
+		}()
+	}
+
+	if consul != "" {
+		settings, err := checkSettings(services)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if s.checks, err = NewChecks(consul, settings); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := s.load(); err != nil {
+		log.Fatal(err)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, syscall.SIGINT)
+
+	results := make(chan outcome)
+
+	// running polls are abandoned on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// concurrent goroutines
+	var wg sync.WaitGroup
+
+	// semaphore to limit number of goroutines
+	sem := make(chan struct{}, c.Limit)
+
+	reload := time.NewTimer(c.reload)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-term:
+			log.Printf("shutting down, waiting for running polls to finish\n")
+			cancel()
+			go func() {
+				for o := range results {
+					if verbose {
+						log.Printf("finished: %s\n", o.name)
+					}
+				}
+			}()
+			wg.Wait()
+			close(results)
+			if err := flushChanges(); err != nil {
+				log.Println(err)
+			}
+			return
+		case <-hup:
+			log.Printf("reloading configuration\n")
+			n, err := loadDaemonConfig(config, def)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			c = n
+			s.config = n
+			setThresholds(c.rules)
+			if list, err := reconfigure(); err != nil {
+				log.Println(err)
+			} else if err := setNotifiers(list).Close(); err != nil {
+				log.Println(err)
+			}
+			if cap(sem) != c.Limit {
+				sem = make(chan struct{}, c.Limit)
+			}
+			if err := s.load(); err != nil {
+				log.Println(err)
+			}
+			reload.Reset(c.reload)
+		case <-reload.C:
+			if err := s.load(); err != nil {
+				log.Println(err)
+			}
+			if _, err := s.checks.Expire(c.stale, time.Now()); err != nil {
+				log.Println(err)
+			}
+			reload.Reset(c.reload)
+		case o := <-results:
+			if t, ok := s.tasks[o.name]; ok {
+				s.reschedule(t, o)
+			}
+		case now := <-tick.C:
+			for _, t := range s.tasks {
+				if t.running || t.next.After(now) {
+					continue
+				}
+
+				select {
+				case sem <- struct{}{}:
+				default:
+					// all workers are busy, try again later
+					continue
+				}
+
+				t.running = true
+				wg.Add(1)
+
+				// running polls release their slot of the semaphore they were started with
+				go func(l *zone.Device, c *DaemonConfig, sem chan struct{}) {
+					defer func() { <-sem; wg.Done() }()
+					results <- s.run(ctx, l, c)
+				}(t.device, c, sem)
+			}
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ozym/zone"
+)
+
+// Discrepancy kinds between the equipment yaml and the dns inventory.
+const (
+	MissingDNS      = "missing-dns"
+	MissingYAML     = "missing-yaml"
+	AddressMismatch = "address"
+	NameMismatch    = "name"
+	ModelMismatch   = "model"
+	CodeMismatch    = "code"
+	PlaceMismatch   = "place"
+)
+
+// Discrepancy is a single difference between a yaml box and its dns entry.
+type Discrepancy struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	YAML string `json:"yaml,omitempty"`
+	DNS  string `json:"dns,omitempty"`
+}
+
+// short lower case host name used to match yaml boxes against dns entries
+func hostname(name string) string {
+	return strings.ToLower(strings.Split(strings.TrimSuffix(name, "."), ".")[0])
+}
+
+func addresses(list []net.IP) string {
+	var s []string
+	for _, ip := range list {
+		s = append(s, ip.String())
+	}
+	return strings.Join(s, ",")
+}
+
+// Reconcile compares the equipment places against the dns devices, matching by name and then by address.
+func Reconcile(places map[string]Place, devices []*zone.Device) []Discrepancy {
+
+	byName := make(map[string]*zone.Device)
+	byIP := make(map[string]*zone.Device)
+	for _, d := range devices {
+		byName[hostname(d.Name)] = d
+		if d.IP != nil {
+			byIP[d.IP.String()] = d
+		}
+	}
+
+	type entry struct {
+		name  string
+		place string
+		box   Box
+	}
+
+	var boxes []entry
+	named := make(map[string]bool)
+	for p, place := range places {
+		for b, box := range place.Equipment {
+			boxes = append(boxes, entry{name: b, place: p, box: box})
+			named[hostname(b)] = true
+		}
+	}
+	sort.Slice(boxes, func(i, j int) bool {
+		return boxes[i].name < boxes[j].name
+	})
+
+	var list []Discrepancy
+
+	matched := make(map[*zone.Device]bool)
+	for _, e := range boxes {
+		ips, err := e.box.Addresses()
+		if err != nil {
+			log.Printf("invalid address for %s: %s\n", e.name, err)
+		}
+
+		d, ok := byName[hostname(e.name)]
+		if !ok {
+			// maybe renamed, look for an unclaimed dns entry with the same address
+			for _, ip := range ips {
+				if x, ok := byIP[ip.String()]; ok && !named[hostname(x.Name)] && !matched[x] {
+					d = x
+					list = append(list, Discrepancy{Name: e.name, Kind: NameMismatch, YAML: e.name, DNS: x.Name})
+					break
+				}
+			}
+		}
+		if d == nil {
+			list = append(list, Discrepancy{Name: e.name, Kind: MissingDNS, YAML: addresses(ips)})
+			continue
+		}
+		matched[d] = true
+
+		var found bool
+		for _, ip := range ips {
+			if ip.Equal(d.IP) {
+				found = true
+			}
+		}
+		if !found && err == nil {
+			list = append(list, Discrepancy{Name: e.name, Kind: AddressMismatch, YAML: addresses(ips), DNS: d.IP.String()})
+		}
+
+		if e.box.Model != d.Model {
+			list = append(list, Discrepancy{Name: e.name, Kind: ModelMismatch, YAML: e.box.Model, DNS: d.Model})
+		}
+
+		var code string
+		if e.box.Code != nil {
+			code = *e.box.Code
+		}
+		if code != d.Code {
+			list = append(list, Discrepancy{Name: e.name, Kind: CodeMismatch, YAML: code, DNS: d.Code})
+		}
+
+		if strings.Join(strings.Fields(e.place), " ") != strings.Join(strings.Fields(d.Place), " ") {
+			list = append(list, Discrepancy{Name: e.name, Kind: PlaceMismatch, YAML: e.place, DNS: d.Place})
+		}
+	}
+
+	for _, d := range devices {
+		if matched[d] {
+			continue
+		}
+		list = append(list, Discrepancy{Name: hostname(d.Name), Kind: MissingYAML, DNS: d.IP.String()})
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+func diffText(w io.Writer, list []Discrepancy) error {
+	tab := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(tab, "NAME\tKIND\tYAML\tDNS\n")
+	for _, d := range list {
+		fmt.Fprintf(tab, "%s\t%s\t%s\t%s\n", d.Name, d.Kind, def(d.YAML, "-"), def(d.DNS, "-"))
+	}
+
+	return tab.Flush()
+}
+
+func diffJSON(w io.Writer, list []Discrepancy) error {
+	if list == nil {
+		list = []Discrepancy{}
+	}
+
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(b, '\n'))
+
+	return err
+}
+
+// def returns the default when the value is blank
+func def(value, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
+
+func diff(args []string) {
+
+	f := flag.NewFlagSet("diff", flag.ExitOnError)
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Compare the equipment yaml file against the dns inventory\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s [options] diff [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "General Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Equipment Diff Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		f.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	var config string
+	f.StringVar(&config, "config", "equipment.yaml", "equipment file to compare")
+
+	var master string
+	f.StringVar(&master, "master", "rhubarb.geonet.org.nz.", "default master for equipment service lookup")
+
+	var lookup string
+	f.StringVar(&lookup, "zone", "wan.geonet.org.nz.", "default zone for equipment service lookup")
+
+	var format string
+	f.StringVar(&format, "format", "text", "diff output format (text or json)")
+
+	var strict bool
+	f.BoolVar(&strict, "strict", false, "exit with an error status if any differences are found")
+
+	if err := f.Parse(args); err != nil {
+		f.Usage()
+
+		log.Fatalf("Invalid option(s) given")
+	}
+
+	c, err := ioutil.ReadFile(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var places map[string]Place
+	if err := yaml.Unmarshal(c, &places); err != nil {
+		log.Fatal(err)
+	}
+
+	details, err := zone.LoadLocal(master, []string{lookup}, []string{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	list := Reconcile(places, details.List)
+
+	claimStdout(os.Stdout)
+
+	switch format {
+	case "json":
+		err = diffJSON(os.Stdout, list)
+	case "text", "":
+		err = diffText(os.Stdout, list)
+	default:
+		log.Fatalf("Unknown diff format: %s", format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if strict && len(list) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ozym/zone"
+)
+
+func TestReconcile(t *testing.T) {
+
+	places := testPlaces(t, `
+Wellington:
+  equipment:
+    wgtn-q330:
+      model: Quanterra Q330
+      code: WGTN
+      address: 192.168.1.10/28
+`)
+
+	device := func(name, ip, model, code, place string) *zone.Device {
+		return &zone.Device{Name: name + ".wan.geonet.org.nz.", IP: net.ParseIP(ip), Model: model, Code: code, Place: place}
+	}
+
+	var tests = []struct {
+		name    string
+		places  map[string]Place
+		devices []*zone.Device
+		found   []Discrepancy
+	}{
+		{
+			name:    "unchanged",
+			places:  places,
+			devices: []*zone.Device{device("wgtn-q330", "192.168.1.10", "Quanterra Q330", "WGTN", "Wellington")},
+		},
+		{
+			name:    "missing dns",
+			places:  places,
+			devices: nil,
+			found:   []Discrepancy{{Name: "wgtn-q330", Kind: MissingDNS, YAML: "192.168.1.10"}},
+		},
+		{
+			name:    "missing yaml",
+			places:  nil,
+			devices: []*zone.Device{device("wgtn-q330", "192.168.1.10", "Quanterra Q330", "WGTN", "Wellington")},
+			found:   []Discrepancy{{Name: "wgtn-q330", Kind: MissingYAML, DNS: "192.168.1.10"}},
+		},
+		{
+			name:    "readdressed",
+			places:  places,
+			devices: []*zone.Device{device("wgtn-q330", "192.168.1.20", "Quanterra Q330", "WGTN", "Wellington")},
+			found:   []Discrepancy{{Name: "wgtn-q330", Kind: AddressMismatch, YAML: "192.168.1.10", DNS: "192.168.1.20"}},
+		},
+		{
+			name:    "renamed",
+			places:  places,
+			devices: []*zone.Device{device("wgtn-datalogger", "192.168.1.10", "Quanterra Q330", "WGTN", "Wellington")},
+			found:   []Discrepancy{{Name: "wgtn-q330", Kind: NameMismatch, YAML: "wgtn-q330", DNS: "wgtn-datalogger.wan.geonet.org.nz."}},
+		},
+		{
+			name:    "changed model",
+			places:  places,
+			devices: []*zone.Device{device("wgtn-q330", "192.168.1.10", "Quanterra Q330+", "WGTN", "Wellington")},
+			found:   []Discrepancy{{Name: "wgtn-q330", Kind: ModelMismatch, YAML: "Quanterra Q330", DNS: "Quanterra Q330+"}},
+		},
+		{
+			name:    "changed code and place",
+			places:  places,
+			devices: []*zone.Device{device("wgtn-q330", "192.168.1.10", "Quanterra Q330", "WEL", "Wellington  City")},
+			found: []Discrepancy{
+				{Name: "wgtn-q330", Kind: CodeMismatch, YAML: "WGTN", DNS: "WEL"},
+				{Name: "wgtn-q330", Kind: PlaceMismatch, YAML: "Wellington", DNS: "Wellington  City"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found := Reconcile(tt.places, tt.devices)
+			if len(found) != len(tt.found) {
+				t.Fatalf("expected %v, got %v", tt.found, found)
+			}
+			for i := range found {
+				if found[i] != tt.found[i] {
+					t.Errorf("expected %v, got %v", tt.found[i], found[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v2"
+
+	"github.com/ozym/zone"
+)
+
+// ZoneFile is a set of records to be written as an RFC 1035 master file.
+type ZoneFile struct {
+	Origin  string
+	Records []dns.RR
+}
+
+// Lines gives the sorted presentation form of the records, excluding the SOA.
+func (z *ZoneFile) Lines() []string {
+	var lines []string
+	for _, rr := range z.Records {
+		lines = append(lines, rr.String())
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// Hash summarises the zone contents and name servers, it is used to decide whether a new serial is needed.
+func (z *ZoneFile) Hash(servers []string) string {
+	lines := z.Lines()
+	for _, s := range servers {
+		lines = append(lines, "NS "+dns.Fqdn(s))
+	}
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(lines, "\n"))))
+}
+
+// reverse lookup zone of an ipv4 address, based on the /24 network
+func reverseZone(ip net.IP) string {
+	p := strings.Split(ip.To4().String(), ".")
+	return p[2] + "." + p[1] + "." + p[0] + ".in-addr.arpa."
+}
+
+// Zones builds the forward zone and per /24 reverse zones from the equipment places.
+func Zones(places map[string]Place, origin string, ttl uint32) (*ZoneFile, map[string]*ZoneFile) {
+	origin = dns.Fqdn(strings.ToLower(origin))
+
+	forward := ZoneFile{Origin: origin}
+	reverse := make(map[string]*ZoneFile)
+
+	hdr := func(name string, rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	}
+
+	fqdn := func(name string) string {
+		return dns.Fqdn(strings.ToLower(name) + "." + strings.TrimSuffix(origin, "."))
+	}
+
+	// walk the places and boxes in order so duplicates are always resolved the same way
+	type entry struct {
+		name, place string
+	}
+	var boxes []entry
+	for p, place := range places {
+		for b := range place.Equipment {
+			boxes = append(boxes, entry{name: b, place: p})
+		}
+	}
+	sort.Slice(boxes, func(i, j int) bool {
+		if boxes[i].place != boxes[j].place {
+			return boxes[i].place < boxes[j].place
+		}
+		return boxes[i].name < boxes[j].name
+	})
+
+	seen := make(map[string]string)
+	for _, e := range boxes {
+		name := fqdn(e.name)
+		if _, ok := seen[name]; !ok {
+			seen[name] = e.place
+		}
+	}
+
+	done := make(map[string]bool)
+	aliased := make(map[string]string)
+	for _, e := range boxes {
+		b, p := e.name, e.place
+		place, box := places[p], places[p].Equipment[b]
+		name := fqdn(b)
+		if other := seen[name]; other != p || done[name] {
+			log.Printf("skipping duplicate %s at %s, already found at %s\n", b, p, other)
+			continue
+		}
+		done[name] = true
+
+		ips, err := box.Addresses()
+		if err != nil || !(len(ips) > 0) {
+			if verbose {
+				log.Printf("skipping %s, no valid address\n", b)
+			}
+			continue
+		}
+
+		d := zone.Device{Name: name, IP: ips[0], Model: box.Model, Place: p}
+		if box.Code != nil {
+			d.Code = *box.Code
+		}
+
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				forward.Records = append(forward.Records, &dns.A{Hdr: hdr(name, dns.TypeA), A: ip.To4()})
+			}
+		}
+
+		if d.Model != "" || d.Code != "" {
+			rr := d.ToHINFO()
+			rr.Hdr.Ttl = ttl
+			forward.Records = append(forward.Records, rr)
+		}
+
+		txt := d.ToTXT()
+		txt.Hdr.Ttl = ttl
+		forward.Records = append(forward.Records, txt)
+
+		if place.Latitude != nil && place.Longitude != nil {
+			d.Latitude, d.Longitude = *place.Latitude, *place.Longitude
+			if place.Height != nil {
+				d.Height = *place.Height
+			}
+			loc := d.ToLOC()
+			loc.Hdr.Ttl = ttl
+			forward.Records = append(forward.Records, loc)
+		}
+
+		for _, a := range box.Aliases {
+			alias := fqdn(a)
+			if other, ok := seen[alias]; ok {
+				log.Printf("skipping alias %s of %s, already used as a name at %s\n", a, b, other)
+				continue
+			}
+			if other, ok := aliased[alias]; ok {
+				log.Printf("skipping alias %s of %s, already an alias of %s\n", a, b, other)
+				continue
+			}
+			aliased[alias] = b
+			forward.Records = append(forward.Records, &dns.CNAME{Hdr: hdr(alias, dns.TypeCNAME), Target: name})
+		}
+
+		for _, ip := range ips {
+			if ip.To4() == nil {
+				continue
+			}
+			z := reverseZone(ip)
+			if _, ok := reverse[z]; !ok {
+				reverse[z] = &ZoneFile{Origin: z}
+			}
+			ptr, err := dns.ReverseAddr(ip.String())
+			if err != nil {
+				continue
+			}
+			reverse[z].Records = append(reverse[z].Records, &dns.PTR{Hdr: hdr(ptr, dns.TypePTR), Ptr: name})
+		}
+	}
+
+	return &forward, reverse
+}
+
+// previous serial and content hash recorded in an existing zone file
+func zoneSerial(path string) (uint32, string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, ""
+	}
+	defer file.Close()
+
+	var serial uint32
+	var hash string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		switch {
+		case len(f) == 3 && f[0] == ";" && f[1] == "sha1":
+			hash = f[2]
+		case len(f) > 6 && f[3] == "SOA":
+			if n, err := strconv.ParseUint(f[6], 10, 32); err == nil {
+				serial = uint32(n)
+			}
+		}
+	}
+
+	return serial, hash
+}
+
+// nextSerial keeps the existing serial if the contents haven't changed, otherwise a date based
+// serial is used which always increases, so generating the same data twice gives the same file.
+func nextSerial(old uint32, changed bool, now time.Time) uint32 {
+	if !changed && old > 0 {
+		return old
+	}
+
+	n, _ := strconv.ParseUint(now.UTC().Format("20060102")+"00", 10, 32)
+	if old >= uint32(n) {
+		return old + 1
+	}
+
+	return uint32(n)
+}
+
+// Write stores the zone file, with the given SOA and name servers, using a temporary file.
+func (z *ZoneFile) Write(path string, soa dns.SOA, servers []string, serial uint32) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	soa.Hdr.Name = z.Origin
+	soa.Serial = serial
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "; generated from the equipment yaml, do not edit\n")
+	fmt.Fprintf(w, "; sha1 %s\n", z.Hash(servers))
+	fmt.Fprintf(w, "$ORIGIN %s\n", z.Origin)
+	fmt.Fprintf(w, "%s\n", soa.String())
+	for _, s := range servers {
+		ns := dns.NS{Hdr: dns.RR_Header{Name: z.Origin, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: soa.Hdr.Ttl}, Ns: dns.Fqdn(s)}
+		fmt.Fprintf(w, "%s\n", ns.String())
+	}
+	for _, l := range z.Lines() {
+		fmt.Fprintf(w, "%s\n", l)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	return os.Chmod(path, 0644)
+}
+
+func exportZone(args []string) {
+
+	f := flag.NewFlagSet("export-zone", flag.ExitOnError)
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Generate forward and reverse dns zone files from the equipment yaml file\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s [options] export-zone [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "General Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Equipment Zone Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		f.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	var config string
+	f.StringVar(&config, "config", "equipment.yaml", "equipment file to export")
+
+	var origin string
+	f.StringVar(&origin, "zone", "wan.geonet.org.nz.", "forward zone to generate")
+
+	var output string
+	f.StringVar(&output, "output", ".", "directory to write the zone files into")
+
+	var servers string
+	f.StringVar(&servers, "ns", "rhubarb.geonet.org.nz.", "comma separated name servers for the zones, the first is the primary")
+
+	var mbox string
+	f.StringVar(&mbox, "mbox", "hostmaster.geonet.org.nz.", "responsible mailbox for the zones")
+
+	var ttl uint
+	f.UintVar(&ttl, "ttl", 86400, "default record time to live")
+
+	var serial uint
+	f.UintVar(&serial, "serial", 0, "optional fixed soa serial, otherwise the serial only changes with the contents")
+
+	if err := f.Parse(args); err != nil {
+		f.Usage()
+
+		log.Fatalf("Invalid option(s) given")
+	}
+
+	c, err := ioutil.ReadFile(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var places map[string]Place
+	if err := yaml.Unmarshal(c, &places); err != nil {
+		log.Fatal(err)
+	}
+
+	ns := strings.Split(servers, ",")
+
+	soa := dns.SOA{
+		Hdr:     dns.RR_Header{Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: uint32(ttl)},
+		Ns:      dns.Fqdn(ns[0]),
+		Mbox:    dns.Fqdn(mbox),
+		Refresh: 10800,
+		Retry:   3600,
+		Expire:  604800,
+		Minttl:  3600,
+	}
+
+	forward, reverse := Zones(places, origin, uint32(ttl))
+
+	list := []*ZoneFile{forward}
+	for _, z := range reverse {
+		list = append(list, z)
+	}
+
+	now := time.Now()
+	for _, z := range list {
+		path := filepath.Join(output, strings.TrimSuffix(z.Origin, ".")+".zone")
+
+		s := uint32(serial)
+		if !(s > 0) {
+			old, hash := zoneSerial(path)
+			s = nextSerial(old, hash != z.Hash(ns), now)
+		}
+
+		if err := z.Write(path, soa, ns, s); err != nil {
+			log.Fatal(err)
+		}
+
+		if verbose {
+			log.Printf("exported %s (%d records, serial %d)\n", path, len(z.Records), s)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ozym/dmc"
+	"github.com/ozym/zone"
+)
+
+// exported holds the latest polling results of a single device.
+type exported struct {
+	labels     string
+	reachable  bool
+	identified bool
+	values     map[string]float64
+	updated    time.Time
+}
+
+// Exporter presents device polling results in the prometheus text format.
+type Exporter struct {
+	mu      sync.Mutex
+	devices map[string]*exported
+	polls   float64
+	last    time.Time
+}
+
+func NewExporter() *Exporter {
+	return &Exporter{devices: make(map[string]*exported)}
+}
+
+var metricName = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// sanitise a state key into a valid prometheus metric name suffix
+func metric(key string) string {
+	return strings.Trim(metricName.ReplaceAllString(strings.ToLower(key), "_"), "_")
+}
+
+func escape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(s)
+}
+
+func labels(l *zone.Device) string {
+	pairs := [][2]string{
+		{"name", l.Name},
+		{"ip", l.IP.String()},
+		{"model", l.Model},
+		{"code", l.Code},
+		{"place", l.Place},
+	}
+
+	var parts []string
+	for _, p := range pairs {
+		parts = append(parts, p[0]+"=\""+escape(p[1])+"\"")
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// Update stores the results of polling a device.
+func (e *Exporter) Update(l *zone.Device, reachable bool, s *dmc.State) {
+	x := exported{
+		labels:     labels(l),
+		reachable:  reachable,
+		identified: s != nil,
+		values:     make(map[string]float64),
+		updated:    time.Now(),
+	}
+
+	if s != nil {
+		for k, v := range s.Values {
+			if k == "timestamp" {
+				continue
+			}
+			if n, ok := numeric(v); ok && !isString(v) {
+				if m := metric(k); m != "" {
+					x.values[m] = n
+				}
+			}
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.devices[l.Name] = &x
+}
+
+// Retain drops any devices no longer found in the inventory.
+func (e *Exporter) Retain(list []*zone.Device) {
+	keep := make(map[string]bool)
+	for _, l := range list {
+		keep[l.Name] = true
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for k := range e.devices {
+		if !keep[k] {
+			delete(e.devices, k)
+		}
+	}
+
+	e.polls++
+	e.last = time.Now()
+}
+
+func isString(v interface{}) bool {
+	_, ok := v.(string)
+	return ok
+}
+
+func bool2float(b bool) float64 {
+	if b {
+		return 1.0
+	}
+	return 0.0
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var names []string
+	for k := range e.devices {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+
+	gauge := func(name, help string, value func(x *exported) (float64, bool)) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", name)
+		for _, n := range names {
+			x := e.devices[n]
+			if v, ok := value(x); ok {
+				fmt.Fprintf(&buf, "%s{%s} %s\n", name, x.labels, strconv.FormatFloat(v, 'f', -1, 64))
+			}
+		}
+	}
+
+	gauge("equipment_reachable", "Whether the device responded to a reachability check.", func(x *exported) (float64, bool) {
+		return bool2float(x.reachable), true
+	})
+	gauge("equipment_identified", "Whether the device was successfully identified.", func(x *exported) (float64, bool) {
+		return bool2float(x.identified), true
+	})
+	gauge("equipment_last_poll_timestamp_seconds", "When the device was last polled.", func(x *exported) (float64, bool) {
+		return float64(x.updated.Unix()), true
+	})
+
+	keys := make(map[string]bool)
+	for _, x := range e.devices {
+		for k := range x.values {
+			keys[k] = true
+		}
+	}
+	var list []string
+	for k := range keys {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	for _, k := range list {
+		gauge("equipment_state_"+k, "Device state value \""+k+"\".", func(x *exported) (float64, bool) {
+			v, ok := x.values[k]
+			return v, ok
+		})
+	}
+
+	fmt.Fprintf(&buf, "# HELP equipment_polls_total Number of completed inventory polling cycles.\n")
+	fmt.Fprintf(&buf, "# TYPE equipment_polls_total counter\n")
+	fmt.Fprintf(&buf, "equipment_polls_total %s\n", strconv.FormatFloat(e.polls, 'f', -1, 64))
+	if !e.last.IsZero() {
+		fmt.Fprintf(&buf, "# HELP equipment_last_cycle_timestamp_seconds When the last polling cycle completed.\n")
+		fmt.Fprintf(&buf, "# TYPE equipment_last_cycle_timestamp_seconds gauge\n")
+		fmt.Fprintf(&buf, "equipment_last_cycle_timestamp_seconds %d\n", e.last.Unix())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// poll runs a single status pass over the inventory, updating the exporter.
+func (e *Exporter) poll(master, lookup, models, sites string, limit int, timeout time.Duration, retries int) error {
+
+	details, err := zone.LoadLocal(master, []string{lookup}, []string{})
+	if err != nil {
+		return err
+	}
+
+	devices, err := details.MatchByModel(models)
+	if err != nil {
+		return err
+	}
+	if devices, err = devices.MatchByName(sites); err != nil {
+		return err
+	}
+
+	// concurrent goroutines
+	var wg sync.WaitGroup
+
+	// semaphore to limit number of goroutines
+	sem := make(chan struct{}, limit)
+
+	for _, l := range devices.List {
+		d := dmc.Device{
+			Name:  l.Name,
+			IP:    l.IP,
+			Model: l.Model,
+		}
+
+		// wait ...
+		sem <- struct{}{}
+		wg.Add(1)
+
+		go func(l *zone.Device, d dmc.Device) {
+			defer func() { <-sem; wg.Done() }()
+
+			r, s := inspect(context.Background(), d, timeout, retries)
+			e.Update(l, r.Reachable(), s)
+		}(l, d)
+	}
+
+	wg.Wait()
+
+	e.Retain(devices.List)
+
+	return nil
+}
+
+func serve(args []string) {
+
+	f := flag.NewFlagSet("serve", flag.ExitOnError)
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Poll equipment state and export the results as prometheus metrics\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s [options] serve [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "General Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Equipment Exporter Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		f.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	var master string
+	f.StringVar(&master, "master", "rhubarb.geonet.org.nz.", "default master for equipment service lookup")
+
+	var lookup string
+	f.StringVar(&lookup, "zone", "wan.geonet.org.nz.", "default zone for equipment service lookup")
+
+	var timeout time.Duration
+	f.DurationVar(&timeout, "timeout", time.Second*10, "provide a service timeout")
+
+	var retries int
+	f.IntVar(&retries, "retries", 3, "provide a service retry")
+
+	var limit int
+	f.IntVar(&limit, "limit", 8, "number of concurrent queries")
+
+	var models string
+	f.StringVar(&models, "models", ".*", "regex expression to match equipment models")
+
+	var sites string
+	f.StringVar(&sites, "sites", ".*", "regex expression to match equipment sites")
+
+	var listen string
+	f.StringVar(&listen, "listen", ":9105", "address to serve metrics on")
+
+	var path string
+	f.StringVar(&path, "path", "/metrics", "metrics url path")
+
+	var interval time.Duration
+	f.DurationVar(&interval, "interval", time.Minute*5, "how often to poll the equipment")
+
+	if err := f.Parse(args); err != nil {
+		f.Usage()
+
+		log.Fatalf("Invalid option(s) given")
+	}
+
+	e := NewExporter()
+
+	go func() {
+		for {
+			start := time.Now()
+			if err := e.poll(master, lookup, models, sites, limit, timeout, retries); err != nil {
+				log.Println(err)
+			}
+			if verbose {
+				log.Printf("polling cycle took %s\n", time.Since(start))
+			}
+			if d := interval - time.Since(start); d > 0 {
+				time.Sleep(d)
+			}
+		}
+	}()
+
+	http.Handle(path, e)
+
+	log.Fatal(http.ListenAndServe(listen, nil))
+}
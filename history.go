@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ozym/dmc"
+)
+
+var (
+	retention  time.Duration
+	maxHistory int
+)
+
+// Entry is a single timestamped device state held in the history.
+type Entry struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Values    map[string]interface{} `json:"values"`
+}
+
+// serialise history file updates, pruning rewrites the file
+var histories sync.Mutex
+
+// tracked size of a history file, so it is only read back when it may need pruning
+type historySize struct {
+	count  int
+	oldest time.Time
+}
+
+// the limits may be exceeded by a tenth before a file is pruned, so it is only rewritten occasionally
+func (h *historySize) due(age time.Duration, limit int, now time.Time) bool {
+	if limit > 0 {
+		slack := limit / 10
+		if !(slack > 0) {
+			slack = 1
+		}
+		if h.count > limit+slack {
+			return true
+		}
+	}
+	if age > 0 && !h.oldest.IsZero() && h.oldest.Before(now.Add(-age-age/10)) {
+		return true
+	}
+	return false
+}
+
+// history file sizes seen so far, protected by the histories mutex
+var historySizes = make(map[string]*historySize)
+
+// per device history file name, blank if it can't be determined
+func historyFile(d dmc.Device) string {
+	_, f := location(d)
+	if f == "" {
+		return ""
+	}
+	return strings.TrimSuffix(f, ".json") + ".jsonl"
+}
+
+// record appends the device state to its history
+func record(d dmc.Device, s *dmc.State) error {
+
+	// couldn't find a model ...
+	if _, ok := s.Values["model"]; !ok {
+		return nil
+	}
+
+	f := historyFile(d)
+	if f == "" {
+		return nil
+	}
+
+	now := time.Now().UTC()
+
+	b, err := json.Marshal(Entry{Timestamp: now, Values: s.Values})
+	if err != nil {
+		return err
+	}
+
+	histories.Lock()
+	defer histories.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if !(retention > 0) && !(maxHistory > 0) {
+		return nil
+	}
+
+	h, ok := historySizes[f]
+	if ok {
+		h.count++
+		if h.oldest.IsZero() {
+			h.oldest = now
+		}
+		if !h.due(retention, maxHistory, now) {
+			return nil
+		}
+	}
+
+	n, oldest, err := prune(f, retention, maxHistory)
+	if err != nil {
+		return err
+	}
+	historySizes[f] = &historySize{count: n, oldest: oldest}
+
+	return nil
+}
+
+// readHistory loads all entries from a history file in time order
+func readHistory(f string) ([]Entry, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+
+	return entries, nil
+}
+
+// prune rewrites the history file when entries fall outside the retention settings,
+// it returns the number of entries kept and the oldest timestamp.
+func prune(f string, age time.Duration, limit int) (int, time.Time, error) {
+	entries, err := readHistory(f)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	keep := entries
+	if age > 0 {
+		cutoff := time.Now().Add(-age)
+		for len(keep) > 0 && keep[0].Timestamp.Before(cutoff) {
+			keep = keep[1:]
+		}
+	}
+	if limit > 0 && len(keep) > limit {
+		keep = keep[len(keep)-limit:]
+	}
+
+	var oldest time.Time
+	if len(keep) > 0 {
+		oldest = keep[0].Timestamp
+	}
+
+	// nothing to do ...
+	if len(keep) == len(entries) {
+		return len(keep), oldest, nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f), ".tmp")
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, e := range keep {
+		b, err := json.Marshal(e)
+		if err != nil {
+			tmp.Close()
+			return 0, time.Time{}, err
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, time.Time{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	if err := os.Rename(tmp.Name(), f); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return len(keep), oldest, os.Chmod(f, 0644)
+}
+
+func history(args []string) {
+
+	f := flag.NewFlagSet("history", flag.ExitOnError)
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Print the timeline of stored states for a device\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s [options] history [options] <device>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "General Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Equipment History Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		f.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	var since time.Duration
+	f.DurationVar(&since, "since", 0, "only show entries newer than this age")
+
+	var values bool
+	f.BoolVar(&values, "values", false, "print the full set of values for each entry")
+
+	if err := f.Parse(args); err != nil {
+		f.Usage()
+
+		log.Fatalf("Invalid option(s) given")
+	}
+
+	if !(len(f.Args()) > 0) {
+		f.Usage()
+
+		log.Fatalf("Missing device name")
+	}
+
+	for _, name := range f.Args() {
+		d := dmc.Device{Name: name}
+
+		file := historyFile(d)
+		if file == "" {
+			log.Fatalf("Invalid device name: %s", name)
+		}
+
+		entries, err := readHistory(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("%s (%d entries)\n", name, len(entries))
+
+		var last *dmc.State
+		for _, e := range entries {
+			s := &dmc.State{Values: e.Values}
+			if since > 0 && e.Timestamp.Before(time.Now().Add(-since)) {
+				last = s
+				continue
+			}
+
+			ts := e.Timestamp.Format(time.RFC3339)
+			switch {
+			case values:
+				fmt.Printf("%s %s\n", ts, s.String())
+			case last == nil:
+				fmt.Printf("%s first seen as %v\n", ts, e.Values["model"])
+			}
+
+			for _, c := range Diff(d, last, s) {
+				fmt.Printf("%s   %s\n", ts, c.String())
+			}
+
+			last = s
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/ozym/dmc"
+)
+
+// KVStore keeps the latest device states in consul, shared between pollers.
+type KVStore struct {
+	kv      *api.KV
+	prefix  string
+	retries int
+}
+
+// optional consul state storage
+var kvstore *KVStore
+
+func NewKVStore(address, prefix string) (*KVStore, error) {
+	config := api.DefaultConfig()
+	if address != "" {
+		config.Address = address
+	}
+
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KVStore{kv: client.KV(), prefix: strings.Trim(prefix, "/"), retries: 5}, nil
+}
+
+// Key gives the consul key of a device state, blank if it can't be determined.
+func (k *KVStore) Key(d dmc.Device) string {
+	host, code := names(d)
+	if host == "" {
+		return ""
+	}
+	return path.Join(k.prefix, code, host)
+}
+
+// Put stores the device state using check-and-set, the pair flags hold the poll time so that
+// a state written by another poller since the last read is only replaced if it is older.
+func (k *KVStore) Put(d dmc.Device, s *dmc.State, polled time.Time) error {
+	if k == nil {
+		return nil
+	}
+
+	// couldn't find a model ...
+	if _, ok := s.Values["model"]; !ok {
+		return nil
+	}
+
+	key := k.Key(d)
+	if key == "" {
+		return nil
+	}
+
+	stamp := uint64(polled.UnixNano())
+
+	for i := 0; i < k.retries; i++ {
+		p, _, err := k.kv.Get(key, nil)
+		if err != nil {
+			return err
+		}
+
+		var index uint64
+		if p != nil {
+			if p.Flags > stamp {
+				// a newer state has already been stored
+				return nil
+			}
+			index = p.ModifyIndex
+		}
+
+		ok, _, err := k.kv.CAS(&api.KVPair{Key: key, Value: s.Marshal(), Flags: stamp, ModifyIndex: index}, nil)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unable to store %s, too many concurrent updates", key)
+}
+
+func decodeState(p *api.KVPair) (*dmc.State, time.Time, error) {
+	s := dmc.State{Values: make(map[string]interface{})}
+	if err := json.Unmarshal(p.Value, &s.Values); err != nil {
+		return nil, time.Time{}, err
+	}
+	return &s, time.Unix(0, int64(p.Flags)).UTC(), nil
+}
+
+// Get recovers the stored state of a device and when it was polled, if any.
+func (k *KVStore) Get(d dmc.Device) (*dmc.State, time.Time, error) {
+	key := k.Key(d)
+	if key == "" {
+		return nil, time.Time{}, nil
+	}
+
+	p, _, err := k.kv.Get(key, nil)
+	if p == nil || err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return decodeState(p)
+}
+
+// KVEntry is a device state recovered from consul.
+type KVEntry struct {
+	Code   string
+	Host   string
+	State  *dmc.State
+	Polled time.Time
+}
+
+// List recovers the stored states below the prefix, optionally for a single site.
+func (k *KVStore) List(site string) ([]KVEntry, error) {
+	prefix := k.prefix + "/"
+	if site != "" {
+		prefix = path.Join(k.prefix, site) + "/"
+	}
+
+	pairs, _, err := k.kv.List(prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []KVEntry
+	for _, p := range pairs {
+		s, t, err := decodeState(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", p.Key, err)
+		}
+		list = append(list, KVEntry{Code: path.Base(path.Dir(p.Key)), Host: path.Base(p.Key), State: s, Polled: t})
+	}
+
+	return list, nil
+}
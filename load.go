@@ -8,6 +8,9 @@ import (
 	"log"
 	"net"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/hashicorp/consul/api"
 )
@@ -18,6 +21,7 @@ type Box struct {
 	Address_   *string  `yaml:"address"`
 	Model      string   `yaml:"model"`
 	Code       *string  `yaml:"code"`
+	Aliases    []string `yaml:"aliases"`
 }
 
 func (b Box) Address() (*net.IP, error) {
@@ -63,6 +67,196 @@ type Place struct {
 	Equipment map[string]Box `yaml:"equipment"`
 	Runnet    *string        `yaml:"runnet"`
 	Tag       *string        `yaml:"tag"`
+	Latitude  *float64       `yaml:"latitude"`
+	Longitude *float64       `yaml:"longitude"`
+	Height    *float64       `yaml:"height"`
+}
+
+// Mapping registers equipment matching a model as a consul service.
+type Mapping struct {
+	Model   string   `yaml:"model"`
+	Service string   `yaml:"service"`
+	Port    int      `yaml:"port"`
+	Tags    []string `yaml:"tags"`
+
+	model *regexp.Regexp
+}
+
+// Catalog describes how the equipment is registered in consul.
+type Catalog struct {
+	Datacenter string    `yaml:"datacenter"`
+	Domain     string    `yaml:"domain"`
+	Services   []Mapping `yaml:"services"`
+}
+
+// the catalog settings used when no config file is given
+var defaultCatalog = Catalog{
+	Datacenter: "avc",
+	Domain:     "wan.geonet.org.nz",
+	Services: []Mapping{
+		{Model: "^Quanterra Q330$", Service: "qdp", Port: 5330},
+		{Model: "^Quanterra Q330\\+$", Service: "qdp", Port: 6330},
+		{Model: "^Trimble NetRS$", Service: "netrs", Port: 80},
+		{Model: "^Trimble NetR9$", Service: "netr9", Port: 80},
+	},
+}
+
+func (c *Catalog) compile() error {
+	var err error
+
+	for i := range c.Services {
+		m := &c.Services[i]
+		if m.Service == "" {
+			return fmt.Errorf("catalog mapping for %q has no service", m.Model)
+		}
+		if m.model, err = regexp.Compile(m.Model); err != nil {
+			return err
+		}
+	}
+	c.Domain = strings.Trim(c.Domain, ".")
+
+	return nil
+}
+
+func LoadCatalog(path string) (*Catalog, error) {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var x Catalog
+	if err := yaml.Unmarshal(c, &x); err != nil {
+		return nil, err
+	}
+
+	if err := x.compile(); err != nil {
+		return nil, err
+	}
+
+	return &x, nil
+}
+
+// Find returns the first service mapping for the model, if any.
+func (c *Catalog) Find(model string) *Mapping {
+	for i := range c.Services {
+		if c.Services[i].model.MatchString(model) {
+			return &c.Services[i]
+		}
+	}
+	return nil
+}
+
+// Managed gives the service names maintained by the sync, any others are left alone.
+func (c *Catalog) Managed() []string {
+	seen := make(map[string]bool)
+
+	var list []string
+	for _, m := range c.Services {
+		if !seen[m.Service] {
+			list = append(list, m.Service)
+			seen[m.Service] = true
+		}
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+// Registrations builds the desired catalog entries, only coded equipment with an address is registered.
+func (c *Catalog) Registrations(places map[string]Place) []*api.CatalogRegistration {
+	var list []*api.CatalogRegistration
+
+	for _, place := range places {
+		for b, box := range place.Equipment {
+			if box.Code == nil || *box.Code == "" {
+				continue
+			}
+			i, err := box.Address()
+			if err != nil || i == nil {
+				continue
+			}
+			m := c.Find(box.Model)
+			if m == nil {
+				continue
+			}
+
+			address := b
+			if c.Domain != "" {
+				address = b + "." + c.Domain
+			}
+
+			list = append(list, &api.CatalogRegistration{
+				Node:       b,
+				Address:    address,
+				Datacenter: c.Datacenter,
+				Service: &api.AgentService{
+					ID:      m.Service,
+					Service: m.Service,
+					Tags:    append([]string{*box.Code}, m.Tags...),
+					Port:    m.Port,
+					Address: i.String(),
+				},
+			})
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Node != list[j].Node {
+			return list[i].Node < list[j].Node
+		}
+		return list[i].Service.Service < list[j].Service.Service
+	})
+
+	return list
+}
+
+// current reports whether a live catalog entry already matches the registration
+func current(r *api.CatalogRegistration, s *api.CatalogService) bool {
+	if r.Address != s.Address || r.Service.Address != s.ServiceAddress || r.Service.Port != s.ServicePort {
+		return false
+	}
+
+	a := append([]string{}, r.Service.Tags...)
+	b := append([]string{}, s.ServiceTags...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
+
+// Plan compares the desired registrations against the live service entries, returning
+// the registrations which are new or have changed, and the live entries no longer wanted.
+func Plan(desired []*api.CatalogRegistration, live []*api.CatalogService) ([]*api.CatalogRegistration, []*api.CatalogService) {
+
+	key := func(node, service string) string {
+		return node + "/" + service
+	}
+
+	existing := make(map[string]*api.CatalogService)
+	for _, s := range live {
+		existing[key(s.Node, s.ServiceID)] = s
+	}
+
+	wanted := make(map[string]bool)
+
+	var register []*api.CatalogRegistration
+	for _, r := range desired {
+		k := key(r.Node, r.Service.ID)
+		wanted[k] = true
+		if s, ok := existing[k]; ok && current(r, s) {
+			continue
+		}
+		register = append(register, r)
+	}
+
+	var deregister []*api.CatalogService
+	for _, s := range live {
+		if !wanted[key(s.Node, s.ServiceID)] {
+			deregister = append(deregister, s)
+		}
+	}
+
+	return register, deregister
 }
 
 func load(args []string) {
@@ -92,134 +286,87 @@ func load(args []string) {
 	var consul string
 	f.StringVar(&consul, "consul", "127.0.0.1:8500", "default consul server to connect to")
 
+	var services string
+	f.StringVar(&services, "services", "", "yaml file giving the datacenter, domain and model to service mappings")
+
+	var dryrun bool
+	f.BoolVar(&dryrun, "dry-run", false, "only print the catalog changes which would be made")
+
 	if err := f.Parse(args); err != nil {
 		f.Usage()
 
 		log.Fatalf("Invalid option(s) given")
 	}
 
-	def := api.DefaultConfig()
-	if def.Address != consul {
-		def.Address = consul
+	settings := &defaultCatalog
+	if services != "" {
+		s, err := LoadCatalog(services)
+		if err != nil {
+			log.Fatal(err)
+		}
+		settings = s
+	} else if err := settings.compile(); err != nil {
+		log.Fatal(err)
 	}
 
-	client, err := api.NewClient(def)
+	c, err := ioutil.ReadFile(config)
 	if err != nil {
 		log.Fatal(err)
 	}
-	catalog := client.Catalog()
-	services, _, err := catalog.Services(&api.QueryOptions{})
+
+	var places map[string]Place
+	err = yaml.Unmarshal(c, &places)
 	if err != nil {
 		log.Fatal(err)
 	}
-	for s, m := range services {
-		for _, x := range m {
-			if s == "netrs" {
-				log.Println(s, x)
-				c, _, err := catalog.Service(s, x, &api.QueryOptions{})
-				if err != nil {
-					log.Fatal(err)
-				}
-				log.Printf("%q\n", c)
-				for _, cc := range c {
-					_, err := catalog.Deregister(&api.CatalogDeregistration{Node: cc.Node, Address: cc.Address, Datacenter: "avc", ServiceID: cc.ServiceID}, &api.WriteOptions{Datacenter: "avc"})
-					if err != nil {
-						log.Fatal(err)
-					}
-				}
-			}
 
-		}
+	def := api.DefaultConfig()
+	if def.Address != consul {
+		def.Address = consul
 	}
 
-	c, err := ioutil.ReadFile(config)
+	client, err := api.NewClient(def)
 	if err != nil {
 		log.Fatal(err)
 	}
+	catalog := client.Catalog()
 
-	var places map[string]Place
-	err = yaml.Unmarshal(c, &places)
-	if err != nil {
-		log.Fatal(err)
+	query := &api.QueryOptions{Datacenter: settings.Datacenter}
+	write := &api.WriteOptions{Datacenter: settings.Datacenter}
+
+	var live []*api.CatalogService
+	for _, s := range settings.Managed() {
+		l, _, err := catalog.Service(s, "", query)
+		if err != nil {
+			log.Fatal(err)
+		}
+		live = append(live, l...)
 	}
 
-	for _, place := range places {
-		for b, box := range place.Equipment {
-			if i, err := box.Address(); err == nil {
-				if box.Code != nil && *box.Code != "" {
-					var r *api.CatalogRegistration
-					switch box.Model {
-					case "Quanterra Q330":
-						r = &api.CatalogRegistration{
-							Node:       b,
-							Address:    b + ".wan.geonet.org.nz",
-							Datacenter: "avc",
-							Service: &api.AgentService{
-								Service: "qdp",
-								Tags: []string{
-									*box.Code,
-								},
-								Port:    5330,
-								Address: i.String(),
-							},
-						}
-					case "Quanterra Q330+":
-						r = &api.CatalogRegistration{
-							Node:       b,
-							Address:    b + ".wan.geonet.org.nz",
-							Datacenter: "avc",
-							Service: &api.AgentService{
-								Service: "qdp",
-								Tags: []string{
-									*box.Code,
-								},
-								Port:    6330,
-								Address: i.String(),
-							},
-						}
-						//log.Println("dc", "node", b, "address", i.String(), "service", "qdp", "port", "6330")
-					case "Trimble NetRS":
-						r = &api.CatalogRegistration{
-							Node:       b,
-							Address:    b + ".wan.geonet.org.nz",
-							Datacenter: "avc",
-							Service: &api.AgentService{
-								Service: "netrs",
-								Tags: []string{
-									*box.Code,
-								},
-								Port:    80,
-								Address: i.String(),
-							},
-						}
-						// tags = box.Code, netrs, p
-						//log.Println("dc", "node", b, "address", i.String(), "service", "http", "port", "80")
-					case "Trimble NetR9":
-						r = &api.CatalogRegistration{
-							Node:       b,
-							Address:    b + ".wan.geonet.org.nz",
-							Datacenter: "avc",
-							Service: &api.AgentService{
-								Service: "netr9",
-								Tags: []string{
-									*box.Code,
-								},
-								Port:    80,
-								Address: i.String(),
-							},
-						}
-						// tags = box.Code, netr9, p
-						//log.Println("dc", "node", b, "address", i.String(), "service", "http", "port", "80")
-					}
-					if r != nil {
-						_, err := catalog.Register(r, &api.WriteOptions{Datacenter: "avc"})
-						if err != nil {
-							log.Fatal(err)
-						}
-					}
-				}
-			}
+	register, deregister := Plan(settings.Registrations(places), live)
+
+	for _, s := range deregister {
+		log.Printf("deregister: %s %s [%s:%d]\n", s.Node, s.ServiceID, s.ServiceAddress, s.ServicePort)
+		if dryrun {
+			continue
+		}
+		d := api.CatalogDeregistration{Node: s.Node, Address: s.Address, Datacenter: settings.Datacenter, ServiceID: s.ServiceID}
+		if _, err := catalog.Deregister(&d, write); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, r := range register {
+		log.Printf("register: %s %s [%s:%d] %v\n", r.Node, r.Service.ID, r.Service.Address, r.Service.Port, r.Service.Tags)
+		if dryrun {
+			continue
+		}
+		if _, err := catalog.Register(r, write); err != nil {
+			log.Fatal(err)
 		}
 	}
 
+	if verbose || dryrun {
+		log.Printf("%d to register, %d to deregister\n", len(register), len(deregister))
+	}
 }
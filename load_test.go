@@ -0,0 +1,175 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"gopkg.in/yaml.v2"
+)
+
+func testCatalog(t *testing.T) *Catalog {
+	c := defaultCatalog
+	if err := c.compile(); err != nil {
+		t.Fatal(err)
+	}
+	return &c
+}
+
+func testPlaces(t *testing.T, data string) map[string]Place {
+	var places map[string]Place
+	if err := yaml.Unmarshal([]byte(data), &places); err != nil {
+		t.Fatal(err)
+	}
+	return places
+}
+
+func TestRegistrations(t *testing.T) {
+	places := testPlaces(t, `
+Wellington:
+  equipment:
+    wgtn-q330:
+      model: Quanterra Q330
+      code: WGTN
+      address: 192.168.1.10/28
+    wgtn-netrs:
+      model: Trimble NetRS
+      code: WGTN
+      addresses: [192.168.1.11/28, 10.0.0.1/24]
+    wgtn-router:
+      model: MikroTik RB433
+      code: WGTN
+      address: 192.168.1.1/28
+    wgtn-spare:
+      model: Quanterra Q330
+      address: 192.168.1.12/28
+    wgtn-nowhere:
+      model: Quanterra Q330
+      code: WGTN
+`)
+
+	list := testCatalog(t).Registrations(places)
+
+	var tests = []struct {
+		node    string
+		service string
+		address string
+		port    int
+	}{
+		{"wgtn-netrs", "netrs", "192.168.1.11", 80},
+		{"wgtn-q330", "qdp", "192.168.1.10", 5330},
+	}
+
+	if len(list) != len(tests) {
+		t.Fatalf("expected %d registrations, got %d", len(tests), len(list))
+	}
+
+	for i, tt := range tests {
+		r := list[i]
+		if r.Node != tt.node || r.Service.ID != tt.service || r.Service.Address != tt.address || r.Service.Port != tt.port {
+			t.Errorf("expected %s %s %s:%d, got %s %s %s:%d", tt.node, tt.service, tt.address, tt.port,
+				r.Node, r.Service.ID, r.Service.Address, r.Service.Port)
+		}
+		if r.Address != tt.node+".wan.geonet.org.nz" {
+			t.Errorf("%s: unexpected node address %s", tt.node, r.Address)
+		}
+		if !(len(r.Service.Tags) > 0) || r.Service.Tags[0] != "WGTN" {
+			t.Errorf("%s: expected the site code tag, got %v", tt.node, r.Service.Tags)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+
+	desired := func(node, address string, port int, tags ...string) *api.CatalogRegistration {
+		return &api.CatalogRegistration{
+			Node:    node,
+			Address: node + ".wan.geonet.org.nz",
+			Service: &api.AgentService{ID: "qdp", Service: "qdp", Address: address, Port: port, Tags: tags},
+		}
+	}
+	live := func(node, address string, port int, tags ...string) *api.CatalogService {
+		return &api.CatalogService{
+			Node:           node,
+			Address:        node + ".wan.geonet.org.nz",
+			ServiceID:      "qdp",
+			ServiceName:    "qdp",
+			ServiceAddress: address,
+			ServicePort:    port,
+			ServiceTags:    tags,
+		}
+	}
+
+	var tests = []struct {
+		name       string
+		desired    []*api.CatalogRegistration
+		live       []*api.CatalogService
+		register   []string
+		deregister []string
+	}{
+		{
+			name:    "unchanged",
+			desired: []*api.CatalogRegistration{desired("wgtn-q330", "192.168.1.10", 5330, "WGTN", "datalogger")},
+			live:    []*api.CatalogService{live("wgtn-q330", "192.168.1.10", 5330, "datalogger", "WGTN")},
+		},
+		{
+			name:     "new",
+			desired:  []*api.CatalogRegistration{desired("wgtn-q330", "192.168.1.10", 5330, "WGTN")},
+			register: []string{"wgtn-q330"},
+		},
+		{
+			name:     "changed tags",
+			desired:  []*api.CatalogRegistration{desired("wgtn-q330", "192.168.1.10", 5330, "WGTN")},
+			live:     []*api.CatalogService{live("wgtn-q330", "192.168.1.10", 5330, "WGTX")},
+			register: []string{"wgtn-q330"},
+		},
+		{
+			name:     "changed port",
+			desired:  []*api.CatalogRegistration{desired("wgtn-q330", "192.168.1.10", 6330, "WGTN")},
+			live:     []*api.CatalogService{live("wgtn-q330", "192.168.1.10", 5330, "WGTN")},
+			register: []string{"wgtn-q330"},
+		},
+		{
+			name:     "readdressed",
+			desired:  []*api.CatalogRegistration{desired("wgtn-q330", "192.168.1.20", 5330, "WGTN")},
+			live:     []*api.CatalogService{live("wgtn-q330", "192.168.1.10", 5330, "WGTN")},
+			register: []string{"wgtn-q330"},
+		},
+		{
+			name:       "stale",
+			live:       []*api.CatalogService{live("wgtn-q330", "192.168.1.10", 5330, "WGTN")},
+			deregister: []string{"wgtn-q330"},
+		},
+		{
+			name:       "renamed",
+			desired:    []*api.CatalogRegistration{desired("wgtn-q330a", "192.168.1.10", 5330, "WGTN")},
+			live:       []*api.CatalogService{live("wgtn-q330", "192.168.1.10", 5330, "WGTN")},
+			register:   []string{"wgtn-q330a"},
+			deregister: []string{"wgtn-q330"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			register, deregister := Plan(tt.desired, tt.live)
+
+			var r, d []string
+			for _, x := range register {
+				r = append(r, x.Node)
+			}
+			for _, x := range deregister {
+				d = append(d, x.Node)
+			}
+			sort.Strings(r)
+			sort.Strings(d)
+
+			if strings.Join(r, ",") != strings.Join(tt.register, ",") {
+				t.Errorf("expected registrations %v, got %v", tt.register, r)
+			}
+			if strings.Join(d, ",") != strings.Join(tt.deregister, ",") {
+				t.Errorf("expected deregistrations %v, got %v", tt.deregister, d)
+			}
+		})
+	}
+}
@@ -1,41 +1,149 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"regexp"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/ozym/dmc"
-
-	"github.com/tbruyelle/hipchat-go/hipchat"
 )
 
 var (
-	verbose bool
-	room    string
-	token   string
-	base    string
+	verbose   bool
+	base      string
+	changelog string
+	vault     *Vault
 )
 
-func chat(msg, colour string, notify bool) error {
+// rebuilds the notifiers from the command line options, used when the daemon is reloaded
+var reconfigure func() (Notifiers, error)
 
-	if token == "" || room == "" {
-		return nil
+// build the notification backends from the config file and any individual flags
+func configure(config, webhook, slack, channel, server, from, to, file, level string) (Notifiers, error) {
+
+	var list Notifiers
+
+	if config != "" {
+		l, err := LoadNotifiers(config)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, l...)
 	}
 
-	r := &hipchat.NotificationRequest{
-		Message: msg,
-		Color:   colour,
-		Notify:  notify,
+	min := ParseLevel(level)
+
+	if server != "" && to != "" && from == "" {
+		list.Close()
+		return nil, fmt.Errorf("email notifications need a sender, use -mail-from or MAIL_FROM")
 	}
 
-	if _, err := hipchat.NewClient(token).Room.Notification(room, r); err != nil {
-		return err
+	if webhook != "" {
+		list = append(list, &WebhookNotifier{URL: webhook, Minimum: min, Timeout: time.Second * 10})
+	}
+	if slack != "" {
+		list = append(list, &SlackNotifier{URL: slack, Channel: channel, Minimum: min, Timeout: time.Second * 10})
+	}
+	if server != "" && to != "" {
+		list = append(list, &EmailNotifier{
+			Server:   server,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+			To:       strings.Split(to, ","),
+			Minimum:  min,
+		})
+	}
+	if file != "" {
+		w, err := openSink(file)
+		if err != nil {
+			list.Close()
+			return nil, err
+		}
+		list = append(list, &WriterNotifier{Writer: w, Minimum: min})
+	}
+
+	return list, nil
+}
+
+// short host name and site code of a device, blank if they can't be determined
+func names(d dmc.Device) (string, string) {
+
+	// cleanup fdqn ...
+	n := strings.Split(d.Name, ".")
+	if !(len(n) > 0) || n[0] == "" {
+		return "", ""
+	}
+	p := strings.Split(n[0], "-")
+	if !(len(p) > 0) {
+		return "", ""
+	}
+
+	return n[0], p[len(p)-1]
+}
+
+// per site directory and state file name for a device, blank if it can't be determined
+func location(d dmc.Device) (string, string) {
+
+	host, code := names(d)
+	if host == "" {
+		return "", ""
+	}
+
+	b := base + "/" + code
+
+	return b, b + "/" + host + ".json"
+}
+
+// recover the previously stored state of a device, if any, a newer state
+// stored in consul by another poller is used in preference to the local file
+func previous(d dmc.Device) (*dmc.State, error) {
+
+	_, f := location(d)
+	if f == "" {
+		return nil, nil
+	}
+
+	var shared *dmc.State
+	var polled time.Time
+	if kvstore != nil {
+		s, t, err := kvstore.Get(d)
+		if err != nil {
+			log.Println(err)
+		}
+		shared, polled = s, t
+	}
+
+	info, err := os.Stat(f)
+	switch {
+	case os.IsNotExist(err):
+		return shared, nil
+	case err != nil:
+		return nil, err
+	case shared != nil && polled.After(info.ModTime()):
+		return shared, nil
 	}
 
-	return nil
+	c, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	s := dmc.State{Values: make(map[string]interface{})}
+	if err := json.Unmarshal(c, &s.Values); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
 }
 
 func store(d dmc.Device, s *dmc.State) error {
@@ -45,24 +153,17 @@ func store(d dmc.Device, s *dmc.State) error {
 		return nil
 	}
 
-	// cleanup fdqn ...
-	n := strings.Split(d.Name, ".")
-	if !(len(n) > 0) {
-		return nil
-	}
-	p := strings.Split(n[0], "-")
-	if !(len(p) > 0) {
+	b, f := location(d)
+	if f == "" {
 		return nil
 	}
 
 	// per site directory
-	b := base + "/" + p[len(p)-1]
 	if err := os.MkdirAll(b, 0755); err != nil {
 		return err
 	}
 
 	// output file name
-	f := b + "/" + n[0] + ".json"
 	file, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
@@ -99,20 +200,14 @@ func notify(d dmc.Device, s *dmc.State) (bool, error) {
 
 	// outgoing message ...
 	items := []interface{}{d.Model, n[0], d.IP.String(), s.Values["model"].(string)}
-	parts := []string{
-		"---{{{... %s ...}}}---",
-		"<b><em>%s</em></b> [%s]",
-		"has been identified to be a",
-		"<b>%s</b>",
-	}
 
 	if verbose {
 		log.Printf("[%s] %s [%s] has been identified to be a %s\n", items...)
 	}
 
-	// hipchat ...
-	msg := fmt.Sprintf(strings.Join(parts, "<br/>"), items...)
-	if err := chat(msg, "red", true); err != nil {
+	subject := fmt.Sprintf("%s [%s] identified", n[0], d.IP.String())
+	msg := fmt.Sprintf("[%s] %s [%s] has been identified to be a %s", items...)
+	if err := currentNotifiers().Notify(WarningLevel, subject, msg); err != nil {
 		return true, err
 	}
 
@@ -121,11 +216,37 @@ func notify(d dmc.Device, s *dmc.State) (bool, error) {
 
 func device(d dmc.Device, s *dmc.State) bool {
 
-	err := store(d, s)
+	changes, err := detect(d, s)
 	if err != nil {
+		log.Println(err)
+	}
+
+	if err := traffic(d, s); err != nil {
+		log.Println(err)
+	}
+
+	if err := store(d, s); err != nil {
 		log.Fatal(err)
 	}
 
+	if err := kvstore.Put(d, s, time.Now()); err != nil {
+		log.Println(err)
+	}
+
+	if err := record(d, s); err != nil {
+		log.Println(err)
+	}
+
+	if r := currentThresholds(); r != nil {
+		if err := r.Evaluate(d, s); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if err := announce(d, changes); err != nil {
+		log.Println(err)
+	}
+
 	ok, err := notify(d, s)
 	if err != nil {
 		log.Println(err)
@@ -134,13 +255,97 @@ func device(d dmc.Device, s *dmc.State) bool {
 	return ok
 }
 
+// interrupt returns a context which is cancelled on an interrupt or terminate signal, or
+// once the optional deadline has passed, a second signal exits straight away.
+func interrupt(deadline time.Duration) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	switch {
+	case deadline > 0:
+		ctx, cancel = context.WithTimeout(context.Background(), deadline)
+	default:
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		if _, ok := <-sig; !ok {
+			return
+		}
+		log.Printf("interrupted, finishing with the results already gathered\n")
+		cancel()
+		if _, ok := <-sig; ok {
+			log.Fatalf("interrupted again, exiting")
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sig)
+		close(sig)
+		cancel()
+	}
+}
+
 func main() {
 
 	flag.BoolVar(&verbose, "verbose", false, "make noise")
 	flag.StringVar(&base, "base", ".", "base status storage directory")
+	flag.DurationVar(&retention, "retention", 0, "how long to keep device state history, zero keeps everything, files are pruned once a tenth over")
+	flag.IntVar(&maxHistory, "history-max", 0, "maximum number of history entries kept per device, zero is unlimited, files are pruned once a tenth over")
+	flag.StringVar(&changelog, "changelog", "", "directory to write a per-run log of device state changes")
+
+	var usage string
+	flag.StringVar(&usage, "usage-interfaces", ".*", "regex expression to match interfaces counted in the monthly usage totals")
+
+	flag.StringVar(&reaching.Method, "reach", ReachICMP, "default reachability check (icmp, udp, tcp or skip)")
+	flag.IntVar(&reaching.Count, "reach-count", 1, "number of reachability probes sent to each device")
+
+	var reachability string
+	flag.StringVar(&reachability, "reach-config", "", "optional yaml file of per model or site reachability checks")
+
+	var config string
+	flag.StringVar(&config, "notify-config", os.Getenv("NOTIFY_CONFIG"), "yaml file listing notification backends")
+
+	var webhook string
+	flag.StringVar(&webhook, "webhook", os.Getenv("NOTIFY_WEBHOOK_URL"), "generic json webhook url for notifications")
+
+	var slack string
+	flag.StringVar(&slack, "slack", os.Getenv("SLACK_WEBHOOK_URL"), "slack compatible incoming webhook url for notifications")
+
+	var channel string
+	flag.StringVar(&channel, "slack-channel", os.Getenv("SLACK_CHANNEL"), "optional slack channel override")
+
+	var server string
+	flag.StringVar(&server, "smtp", os.Getenv("SMTP_SERVER"), "smtp server (host:port) for email notifications")
+
+	var from string
+	flag.StringVar(&from, "mail-from", os.Getenv("MAIL_FROM"), "email notification sender")
+
+	var to string
+	flag.StringVar(&to, "mail-to", os.Getenv("MAIL_TO"), "comma separated email notification recipients")
+
+	var file string
+	flag.StringVar(&file, "notify-file", "", "append notifications to a file, use \"-\" for stdout, or stderr when stdout carries command results")
 
-	flag.StringVar(&room, "room", os.Getenv("HIPCHAT_ROOM_NAME"), "hipchat room name")
-	flag.StringVar(&token, "token", os.Getenv("HIPCHAT_ROOM_TOKEN"), "hipchat room token")
+	var kvConsul string
+	flag.StringVar(&kvConsul, "consul-kv", "", "optional consul server to also store device states in")
+
+	var kvPrefix string
+	flag.StringVar(&kvPrefix, "consul-kv-prefix", "equipment", "consul key prefix for stored device states")
+
+	var secrets string
+	flag.StringVar(&secrets, "credentials", os.Getenv("EQUIPMENT_CREDENTIALS"), "optional yaml credentials file, may be encrypted")
+
+	var definitions string
+	flag.StringVar(&definitions, "snmp-models", os.Getenv("EQUIPMENT_SNMP_MODELS"), "optional yaml file of generic snmp model definitions")
+
+	var scrapers string
+	flag.StringVar(&scrapers, "http-models", os.Getenv("EQUIPMENT_HTTP_MODELS"), "optional yaml file of generic web scraping model definitions")
+
+	var level string
+	flag.StringVar(&level, "notify-level", "info", "minimum notification level (info, warning, critical)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "\n")
@@ -160,6 +365,14 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  status   -- check existing equipment for state changes\n")
 		fmt.Fprintf(os.Stderr, "  template -- apply go template using yaml equipment file as source\n")
 		fmt.Fprintf(os.Stderr, "  load     -- load yaml equipment files into consul\n")
+		fmt.Fprintf(os.Stderr, "  history  -- print the stored state timeline of a device\n")
+		fmt.Fprintf(os.Stderr, "  report   -- summarise stored states against the inventory\n")
+		fmt.Fprintf(os.Stderr, "  audit    -- check stored firmware versions against a policy\n")
+		fmt.Fprintf(os.Stderr, "  diff     -- compare the equipment yaml file against dns\n")
+		fmt.Fprintf(os.Stderr, "  export-zone -- generate dns zone files from the equipment yaml file\n")
+		fmt.Fprintf(os.Stderr, "  serve    -- poll equipment status and export prometheus metrics\n")
+		fmt.Fprintf(os.Stderr, "  daemon   -- continuously run check and status on a per device schedule\n")
+		fmt.Fprintf(os.Stderr, "  credentials -- encrypt or decrypt a credentials file\n")
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "Use: \"%s <command> --help\" for more information about a specific command\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\n")
@@ -167,6 +380,68 @@ func main() {
 
 	flag.Parse()
 
+	if err := reaching.compile(); err != nil {
+		log.Fatal(err)
+	}
+	if reachability != "" {
+		list, err := loadReaches(reachability)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reaches = list
+	}
+
+	r, err := regexp.Compile(usage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	usageInterfaces = r
+
+	reconfigure = func() (Notifiers, error) {
+		return configure(config, webhook, slack, channel, server, from, to, file, level)
+	}
+
+	list, err := reconfigure()
+	if err != nil {
+		log.Fatal(err)
+	}
+	setNotifiers(list)
+
+	if kvConsul != "" {
+		k, err := NewKVStore(kvConsul, kvPrefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		kvstore = k
+	}
+
+	if secrets != "" {
+		v, err := LoadVault(secrets)
+		if err != nil {
+			log.Fatal(err)
+		}
+		vault = v
+		dmc.Credentials = v.Lookup
+	}
+
+	for _, x := range []struct {
+		path   string
+		loader func([]byte) ([]dmc.Model, error)
+	}{{definitions, dmc.LoadSNMPModels}, {scrapers, dmc.LoadHTTPModels}} {
+		if x.path == "" {
+			continue
+		}
+		c, err := ioutil.ReadFile(x.path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		models, err := x.loader(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dmc.Register(models...)
+	}
+
 	args := flag.Args()
 	if !(len(args) > 0) {
 		flag.Usage()
@@ -183,10 +458,29 @@ func main() {
 		load(args[1:])
 	case "template":
 		plate(args[1:])
+	case "history":
+		history(args[1:])
+	case "report":
+		report(args[1:])
+	case "audit":
+		audit(args[1:])
+	case "diff":
+		diff(args[1:])
+	case "export-zone":
+		exportZone(args[1:])
+	case "serve":
+		serve(args[1:])
+	case "daemon":
+		daemon(args[1:])
+	case "credentials":
+		credentials(args[1:])
 	default:
 		flag.Usage()
 
 		log.Fatalf("Unknown command: %s", args[0])
 	}
 
+	if err := currentNotifiers().Close(); err != nil {
+		log.Println(err)
+	}
 }
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Level indicates the severity of a notification.
+type Level int
+
+const (
+	InfoLevel Level = iota
+	WarningLevel
+	CriticalLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case CriticalLevel:
+		return "critical"
+	case WarningLevel:
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+// ParseLevel converts a level name into a Level, unknown names are treated as info.
+func ParseLevel(s string) Level {
+	switch strings.ToLower(s) {
+	case "critical", "crit", "error":
+		return CriticalLevel
+	case "warning", "warn":
+		return WarningLevel
+	default:
+		return InfoLevel
+	}
+}
+
+// Notifier sends event messages to an external destination.
+type Notifier interface {
+	Notify(level Level, subject, message string) error
+}
+
+// Notifiers allows sending a message to a set of destinations.
+type Notifiers []Notifier
+
+func (n Notifiers) Notify(level Level, subject, message string) error {
+	var errs []string
+	for _, x := range n {
+		if err := x.Notify(level, subject, message); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("notify: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Close releases any files held open by the notifiers.
+func (n Notifiers) Close() error {
+	var errs []string
+	for _, x := range n {
+		c, ok := x.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := c.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("close: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// the active notifiers, these may be rebuilt by the daemon while devices are being polled
+var active struct {
+	sync.RWMutex
+	notifiers Notifiers
+}
+
+func currentNotifiers() Notifiers {
+	active.RLock()
+	defer active.RUnlock()
+
+	return active.notifiers
+}
+
+// setNotifiers replaces the active notifiers, the previous set is returned so it can be closed
+func setNotifiers(n Notifiers) Notifiers {
+	active.Lock()
+	defer active.Unlock()
+
+	old := active.notifiers
+	active.notifiers = n
+
+	return old
+}
+
+// WebhookNotifier posts a generic JSON document to a URL.
+type WebhookNotifier struct {
+	URL     string
+	Minimum Level
+	Timeout time.Duration
+}
+
+func (w *WebhookNotifier) Notify(level Level, subject, message string) error {
+	if level < w.Minimum {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"level":     level.String(),
+		"subject":   subject,
+		"message":   message,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	return post(w.URL, body, w.Timeout)
+}
+
+// SlackNotifier posts a message to a Slack compatible incoming webhook.
+type SlackNotifier struct {
+	URL     string
+	Channel string
+	Minimum Level
+	Timeout time.Duration
+}
+
+func (s *SlackNotifier) colour(level Level) string {
+	switch level {
+	case CriticalLevel:
+		return "danger"
+	case WarningLevel:
+		return "warning"
+	default:
+		return "good"
+	}
+}
+
+func (s *SlackNotifier) Notify(level Level, subject, message string) error {
+	if level < s.Minimum {
+		return nil
+	}
+
+	msg := map[string]interface{}{
+		"text": subject,
+		"attachments": []map[string]interface{}{
+			{
+				"color":    s.colour(level),
+				"text":     message,
+				"fallback": subject,
+			},
+		},
+	}
+	if s.Channel != "" {
+		msg["channel"] = s.Channel
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return post(s.URL, body, s.Timeout)
+}
+
+// EmailNotifier sends a plain text message via an SMTP server.
+type EmailNotifier struct {
+	Server   string
+	Username string
+	Password string
+	From     string
+	To       []string
+	Minimum  Level
+}
+
+func (e *EmailNotifier) prefix(level Level) string {
+	switch level {
+	case CriticalLevel:
+		return "[CRITICAL]"
+	case WarningLevel:
+		return "[WARNING]"
+	default:
+		return "[INFO]"
+	}
+}
+
+func (e *EmailNotifier) Notify(level Level, subject, message string) error {
+	if level < e.Minimum || !(len(e.To) > 0) {
+		return nil
+	}
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		host := e.Server
+		if i := strings.LastIndex(host, ":"); i > 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", e.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s %s\r\n", e.prefix(level), subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&buf, "\r\n%s\r\n", message)
+
+	return smtp.SendMail(e.Server, auth, e.From, e.To, buf.Bytes())
+}
+
+// WriterNotifier writes a single line per message, usually to stdout or a log file.
+type WriterNotifier struct {
+	Writer  io.Writer
+	Minimum Level
+
+	mu sync.Mutex
+}
+
+func (w *WriterNotifier) Notify(level Level, subject, message string) error {
+	if level < w.Minimum {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	msg := strings.Join(strings.Fields(message), " ")
+	_, err := fmt.Fprintf(w.Writer, "%s %-8s %s: %s\n", time.Now().UTC().Format(time.RFC3339), level.String(), subject, msg)
+
+	return err
+}
+
+// Close closes the underlying file, the console is left open.
+func (w *WriterNotifier) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if c, ok := w.Writer.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+func post(url string, body []byte, timeout time.Duration) error {
+	cli := &http.Client{Timeout: timeout}
+
+	resp, err := cli.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: unexpected status %s", url, resp.Status)
+	}
+
+	return nil
+}
+
+// NotifierConfig describes a single notification backend in a config file.
+type NotifierConfig struct {
+	Type    string `yaml:"type"`
+	Level   string `yaml:"level"`
+	Timeout string `yaml:"timeout"`
+
+	// webhook & slack
+	URL     string `yaml:"url"`
+	Channel string `yaml:"channel"`
+
+	// email
+	Server   string   `yaml:"server"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+
+	// file, use "-" for stdout (stderr if stdout carries command results)
+	Path string `yaml:"path"`
+}
+
+func (c NotifierConfig) Notifier() (Notifier, error) {
+	timeout := time.Second * 10
+	if c.Timeout != "" {
+		t, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		timeout = t
+	}
+
+	level := ParseLevel(c.Level)
+
+	switch strings.ToLower(c.Type) {
+	case "webhook":
+		return &WebhookNotifier{URL: c.URL, Minimum: level, Timeout: timeout}, nil
+	case "slack":
+		return &SlackNotifier{URL: c.URL, Channel: c.Channel, Minimum: level, Timeout: timeout}, nil
+	case "email", "smtp":
+		if c.From == "" {
+			return nil, fmt.Errorf("email notifier has no from address")
+		}
+		return &EmailNotifier{
+			Server:   c.Server,
+			Username: c.Username,
+			Password: c.Password,
+			From:     c.From,
+			To:       c.To,
+			Minimum:  level,
+		}, nil
+	case "file", "stdout":
+		w, err := openSink(c.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &WriterNotifier{Writer: w, Minimum: level}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier type: %s", c.Type)
+	}
+}
+
+// LoadNotifiers reads a yaml list of notifier backends.
+func LoadNotifiers(path string) (Notifiers, error) {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []NotifierConfig
+	if err := yaml.Unmarshal(c, &configs); err != nil {
+		return nil, err
+	}
+
+	var list Notifiers
+	for _, x := range configs {
+		n, err := x.Notifier()
+		if err != nil {
+			list.Close()
+			return nil, err
+		}
+		list = append(list, n)
+	}
+
+	return list, nil
+}
+
+// console writes notifications to stdout, or to stderr once stdout is carrying command results
+type console struct{}
+
+func (console) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&resultsOnStdout) != 0 {
+		return os.Stderr.Write(b)
+	}
+	return os.Stdout.Write(b)
+}
+
+func openSink(path string) (io.Writer, error) {
+	switch path {
+	case "", "-":
+		return console{}, nil
+	default:
+		return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+
+	"github.com/ozym/dmc"
+)
+
+// Result error classes.
+const (
+	ResultUnreachable  = "unreachable"
+	ResultUnidentified = "unidentified"
+	ResultCancelled    = "cancelled"
+)
+
+// Result is the outcome of checking a single device.
+type Result struct {
+	Name      string                 `json:"name"`
+	IP        string                 `json:"ip"`
+	Expected  string                 `json:"expected"`
+	Model     string                 `json:"model,omitempty"`
+	Reachable bool                   `json:"reachable"`
+	Error     string                 `json:"error,omitempty"`
+	Values    map[string]interface{} `json:"values,omitempty"`
+}
+
+// state values included in results by default
+var resultKeys = []string{"serial", "firmware", "software", "version", "voltage", "temperature", "uptime", "rtt", "packet_loss"}
+
+func NewResult(d dmc.Device, reachable, cancelled bool, s *dmc.State, keys []string) Result {
+	r := Result{
+		Name:      d.Name,
+		IP:        d.IP.String(),
+		Expected:  d.Model,
+		Reachable: reachable,
+	}
+
+	switch {
+	case s != nil:
+		r.Model, _ = s.Values["model"].(string)
+		r.Values = make(map[string]interface{})
+		for _, k := range keys {
+			if v, ok := s.Values[k]; ok {
+				r.Values[k] = v
+			}
+		}
+	case cancelled:
+		r.Error = ResultCancelled
+	case !reachable:
+		r.Error = ResultUnreachable
+	default:
+		r.Error = ResultUnidentified
+	}
+
+	return r
+}
+
+// Output writes device results to a stream as json lines, csv, or an aligned table.
+type Output struct {
+	mu     sync.Mutex
+	format string
+	keys   []string
+	header bool
+
+	w   io.Writer
+	csv *csv.Writer
+	tab *tabwriter.Writer
+}
+
+// set once command results are written to stdout, notifications are then kept out of the way
+var resultsOnStdout int32
+
+func claimStdout(w io.Writer) {
+	if w == os.Stdout {
+		atomic.StoreInt32(&resultsOnStdout, 1)
+	}
+}
+
+func NewOutput(w io.Writer, format string, keys []string) (*Output, error) {
+	o := Output{format: format, w: w}
+	for _, k := range keys {
+		if k = strings.TrimSpace(k); k != "" {
+			o.keys = append(o.keys, k)
+		}
+	}
+
+	switch format {
+	case "", "none":
+		return nil, nil
+	case "jsonl", "json":
+	case "csv":
+		o.csv = csv.NewWriter(w)
+	case "table", "text":
+		o.tab = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	default:
+		return nil, fmt.Errorf("unknown output format: %s", format)
+	}
+
+	claimStdout(w)
+
+	return &o, nil
+}
+
+func (o *Output) columns(r Result) []string {
+	row := []string{r.Name, r.IP, r.Expected, r.Model, fmt.Sprint(r.Reachable), r.Error}
+	for _, k := range o.keys {
+		switch v, ok := r.Values[k]; {
+		case ok:
+			row = append(row, fmt.Sprint(v))
+		default:
+			row = append(row, "")
+		}
+	}
+	return row
+}
+
+func (o *Output) titles() []string {
+	return append([]string{"name", "ip", "expected", "model", "reachable", "error"}, o.keys...)
+}
+
+// Keys gives the state values included in each result.
+func (o *Output) Keys() []string {
+	if o == nil {
+		return resultKeys
+	}
+	return o.keys
+}
+
+// Write adds a single result to the output, it is safe for concurrent use.
+func (o *Output) Write(r Result) error {
+	if o == nil {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	switch {
+	case o.csv != nil:
+		if !o.header {
+			if err := o.csv.Write(o.titles()); err != nil {
+				return err
+			}
+			o.header = true
+		}
+		if err := o.csv.Write(o.columns(r)); err != nil {
+			return err
+		}
+		// keep the output flowing for pipes
+		o.csv.Flush()
+		return o.csv.Error()
+	case o.tab != nil:
+		if !o.header {
+			if _, err := fmt.Fprintln(o.tab, strings.ToUpper(strings.Join(o.titles(), "\t"))); err != nil {
+				return err
+			}
+			o.header = true
+		}
+		row := o.columns(r)
+		for i := range row {
+			if row[i] == "" {
+				row[i] = "-"
+			}
+		}
+		_, err := fmt.Fprintln(o.tab, strings.Join(row, "\t"))
+		return err
+	default:
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		_, err = o.w.Write(append(b, '\n'))
+		return err
+	}
+}
+
+// Close flushes any buffered output, tables are only aligned once all results are known.
+func (o *Output) Close() error {
+	if o == nil {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	switch {
+	case o.csv != nil:
+		if !o.header {
+			if err := o.csv.Write(o.titles()); err != nil {
+				return err
+			}
+		}
+		o.csv.Flush()
+		return o.csv.Error()
+	case o.tab != nil:
+		return o.tab.Flush()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/paulstuart/ping"
+
+	"github.com/ozym/dmc"
+)
+
+// Reachability check methods.
+const (
+	ReachICMP = "icmp" // raw icmp, needs privileges
+	ReachUDP  = "udp"  // unprivileged datagram icmp
+	ReachTCP  = "tcp"  // tcp connect to the driver management port
+	ReachSkip = "skip" // assume the device is reachable
+)
+
+// Reach selects how the reachability of devices matching a model or site is checked.
+type Reach struct {
+	Model  string `yaml:"model"`
+	Site   string `yaml:"site"`
+	Method string `yaml:"method"`
+	Port   int    `yaml:"port"`
+	Count  int    `yaml:"count"`
+
+	selector selector
+}
+
+func (r *Reach) compile() error {
+	var err error
+
+	if r.selector, err = newSelector(r.Model, r.Site); err != nil {
+		return err
+	}
+	switch r.Method {
+	case "", ReachICMP, ReachUDP, ReachTCP, ReachSkip:
+	default:
+		return fmt.Errorf("unknown reachability method: %s", r.Method)
+	}
+
+	return nil
+}
+
+// Match checks the entry against the device model and site code.
+func (r *Reach) Match(model, site string) bool {
+	return r.selector.match(model, site)
+}
+
+// the default reachability method, and any per model or site overrides
+var (
+	reaching = Reach{Method: ReachICMP, Count: 1}
+	reaches  []*Reach
+)
+
+func loadReaches(path string) ([]*Reach, error) {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Reach
+	if err := yaml.Unmarshal(c, &list); err != nil {
+		return nil, err
+	}
+
+	for _, r := range list {
+		if err := r.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+// the reachability settings for a device, the first matching entry overrides the defaults
+func reachFor(d dmc.Device) Reach {
+	r := reaching
+
+	_, site := names(d)
+	for _, x := range reaches {
+		if !x.Match(d.Model, site) {
+			continue
+		}
+		if x.Method != "" {
+			r.Method = x.Method
+		}
+		if x.Port > 0 {
+			r.Port = x.Port
+		}
+		if x.Count > 0 {
+			r.Count = x.Count
+		}
+		break
+	}
+
+	if !(r.Count > 0) {
+		r.Count = 1
+	}
+
+	return r
+}
+
+// Reachability summarises a set of probes.
+type Reachability struct {
+	Method string
+	Sent   int
+	Lost   int
+	RTT    time.Duration // average of the replies
+}
+
+func (r *Reachability) Reachable() bool {
+	return r.Method == ReachSkip || r.Lost < r.Sent
+}
+
+// Values adds the probe results to a device state, the round trip time is in milliseconds.
+func (r *Reachability) Values(s *dmc.State) {
+	if r.Method == ReachSkip || !(r.Sent > 0) {
+		return
+	}
+	if r.Lost < r.Sent {
+		s.Values["rtt"] = math.Floor(float64(r.RTT)/float64(time.Microsecond)+0.5) / 1000.0
+	}
+	s.Values["packet_loss"] = 100.0 * float64(r.Lost) / float64(r.Sent)
+}
+
+// the icmp echo sequence number, shared by all probes
+var echoSequence = uint32(os.Getpid())
+
+func echo(seq int) []byte {
+	b := []byte{8, 0, 0, 0, 0, 0, byte(seq >> 8), byte(seq)}
+
+	// identifier, replaced by the kernel for datagram sockets
+	id := os.Getpid() & 0xffff
+	b[4], b[5] = byte(id>>8), byte(id)
+
+	b = append(b, []byte("equipment reachability")...)
+
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	sum = sum>>16 + sum&0xffff
+	sum = sum + sum>>16
+
+	c := ^uint16(sum)
+	b[2], b[3] = byte(c>>8), byte(c)
+
+	return b
+}
+
+// probe sends a single check using the given method
+func probe(ctx context.Context, method string, ip net.IP, port int, timeout time.Duration) (time.Duration, error) {
+
+	if t, ok := ctx.Deadline(); ok && time.Until(t) < timeout {
+		timeout = time.Until(t)
+	}
+
+	start := time.Now()
+
+	switch method {
+	case ReachTCP:
+		d := net.Dialer{Timeout: timeout}
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+		if err != nil {
+			// a refused connection still shows the device is there
+			if strings.Contains(err.Error(), "refused") {
+				return time.Since(start), nil
+			}
+			return 0, err
+		}
+		conn.Close()
+	case ReachUDP:
+		seq := int(atomic.AddUint32(&echoSequence, 1) & 0xffff)
+		if err := datagramEcho(ctx, ip, echo(seq), seq, timeout); err != nil {
+			return 0, err
+		}
+	default:
+		secs := int(math.Ceil(timeout.Seconds()))
+		if !(secs > 0) {
+			secs = 1
+		}
+		if err := ping.Pinger(ip.String(), secs); err != nil {
+			return 0, err
+		}
+	}
+
+	return time.Since(start), nil
+}
+
+// reachable checks whether a device can be reached using the method selected for it.
+func reachable(ctx context.Context, d dmc.Device, timeout time.Duration) *Reachability {
+	c := reachFor(d)
+
+	r := Reachability{Method: c.Method}
+	if c.Method == ReachSkip {
+		return &r
+	}
+
+	port := c.Port
+	if c.Method == ReachTCP && !(port > 0) {
+		if port = dmc.ServicePort(d.Model); !(port > 0) {
+			// no tcp service is known for the model
+			r.Method = ReachUDP
+		}
+	}
+
+	var total time.Duration
+	for i := 0; i < c.Count && ctx.Err() == nil; i++ {
+		r.Sent++
+
+		rtt, err := probe(ctx, r.Method, d.IP, port, timeout)
+		if err != nil {
+			r.Lost++
+			if verbose {
+				log.Printf("unreachable: %s (%s): %s\n", d.String(), r.Method, err)
+			}
+			continue
+		}
+		total += rtt
+	}
+
+	if r.Lost < r.Sent {
+		r.RTT = total / time.Duration(r.Sent-r.Lost)
+	}
+
+	return &r
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+func datagramEcho(ctx context.Context, ip net.IP, msg []byte, seq int, timeout time.Duration) error {
+	return fmt.Errorf("unprivileged icmp is not supported on this platform")
+}
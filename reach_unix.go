@@ -0,0 +1,79 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// datagramEcho sends an icmp echo request using an unprivileged datagram socket, on linux
+// this needs the group to be allowed by the net.ipv4.ping_group_range sysctl, darwin includes
+// the ip header in each reply.
+func datagramEcho(ctx context.Context, ip net.IP, msg []byte, seq int, timeout time.Duration) error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_ICMP)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+
+	f := os.NewFile(uintptr(fd), "icmp")
+	c, err := net.FilePacketConn(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	// unblock the read on cancellation
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	if _, err := c.WriteTo(msg, &net.UDPAddr{IP: ip}); err != nil {
+		return err
+	}
+
+	b := make([]byte, 1500)
+	for {
+		n, peer, err := c.ReadFrom(b)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if a, ok := peer.(*net.UDPAddr); ok && !a.IP.Equal(ip) {
+			continue
+		}
+		p := b[:n]
+		if runtime.GOOS == "darwin" && len(p) > 0 && p[0]>>4 == 4 {
+			if hl := int(p[0]&0x0f) * 4; len(p) >= hl {
+				p = p[hl:]
+			}
+		}
+		if len(p) < 8 {
+			continue
+		}
+		switch {
+		case p[0] == 0 && int(p[6])<<8|int(p[7]) == seq:
+			return nil
+		case p[0] == 3:
+			return fmt.Errorf("destination unreachable")
+		}
+	}
+}
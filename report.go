@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ozym/dmc"
+	"github.com/ozym/zone"
+)
+
+// Section is a titled table of report rows.
+type Section struct {
+	Title   string
+	Columns []string
+	Rows    [][]string
+}
+
+// Report summarises the stored device states against the zone inventory.
+type Report struct {
+	Generated time.Time
+	Base      string
+	Sections  []Section
+}
+
+// stored is a device state file recovered from the base directory
+type stored struct {
+	file    string
+	values  map[string]interface{}
+	updated time.Time
+}
+
+// the state keys which may hold a device firmware or software version
+var versionKeys = []string{"firmware", "software", "version", "sysver"}
+
+func (s *stored) text(key string) string {
+	if s == nil {
+		return ""
+	}
+	if v, ok := s.values[key]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+func (s *stored) version() string {
+	for _, k := range versionKeys {
+		if v := s.text(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// states reads every device state file below the base directory, history, alert and usage files are skipped
+func states(dir string) (map[string]*stored, error) {
+
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	list := make(map[string]*stored)
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		c, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		s := stored{file: f, updated: info.ModTime(), values: make(map[string]interface{})}
+		if err := json.Unmarshal(c, &s.values); err != nil {
+			log.Printf("skipping %s: %s\n", f, err)
+			continue
+		}
+		list[filepath.Clean(f)] = &s
+	}
+
+	return list, nil
+}
+
+// shared adds the device states stored in consul, replacing any older local state files
+func shared(dir string, list map[string]*stored, kv *KVStore) error {
+	if kv == nil {
+		return nil
+	}
+
+	entries, err := kv.List("")
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		f := filepath.Clean(filepath.Join(dir, e.Code, e.Host+".json"))
+		if s, ok := list[f]; ok && !e.Polled.After(s.updated) {
+			continue
+		}
+		list[f] = &stored{file: f, values: e.State.Values, updated: e.Polled}
+	}
+
+	return nil
+}
+
+// age gives a short readable duration
+func age(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+}
+
+func count(m map[string]int) [][]string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	var rows [][]string
+	for _, k := range keys {
+		rows = append(rows, []string{k, fmt.Sprint(m[k])})
+	}
+	return rows
+}
+
+// NewReport builds the report sections for the selected devices, states older than the stale age are flagged.
+// Orphaned states are those not belonging to any device in the full inventory.
+func NewReport(devices, inventory []*zone.Device, found map[string]*stored, stale time.Duration) *Report {
+
+	r := Report{Generated: time.Now().UTC(), Base: base}
+
+	models := make(map[string]int)
+	versions := make(map[string]map[string]int)
+
+	var missing, old, serials [][]string
+
+	used := make(map[string]bool)
+	for _, l := range inventory {
+		if _, f := location(dmc.Device{Name: l.Name, IP: l.IP, Model: l.Model}); f != "" {
+			used[filepath.Clean(f)] = true
+		}
+	}
+
+	for _, l := range devices {
+		d := dmc.Device{Name: l.Name, IP: l.IP, Model: l.Model}
+
+		_, f := location(d)
+		if f == "" {
+			continue
+		}
+		f = filepath.Clean(f)
+
+		s, ok := found[f]
+		if !ok {
+			missing = append(missing, []string{l.Name, l.IP.String(), l.Model})
+			continue
+		}
+
+		model := s.text("model")
+		if model == "" {
+			model = l.Model
+		}
+		models[model]++
+
+		if _, ok := versions[model]; !ok {
+			versions[model] = make(map[string]int)
+		}
+		v := s.version()
+		if v == "" {
+			v = "unknown"
+		}
+		versions[model][v]++
+
+		if stale > 0 && time.Since(s.updated) > stale {
+			old = append(old, []string{l.Name, model, s.updated.UTC().Format(time.RFC3339), age(time.Since(s.updated))})
+		}
+
+		serials = append(serials, []string{l.Name, model, s.text("serial"), s.version()})
+	}
+
+	// states for devices no longer in the inventory
+	var orphans [][]string
+	for f, s := range found {
+		if used[f] {
+			continue
+		}
+		orphans = append(orphans, []string{strings.TrimSuffix(filepath.Base(f), ".json"), s.text("model"), s.updated.UTC().Format(time.RFC3339)})
+	}
+
+	var dist [][]string
+	for _, m := range count(models) {
+		for _, v := range count(versions[m[0]]) {
+			dist = append(dist, []string{m[0], v[0], v[1]})
+		}
+	}
+
+	for _, rows := range [][][]string{missing, old, serials, orphans} {
+		sort.Slice(rows, func(i, j int) bool {
+			return rows[i][0] < rows[j][0]
+		})
+	}
+
+	r.Sections = []Section{
+		{Title: "Summary", Columns: []string{"item", "count"}, Rows: [][]string{
+			{"inventory", fmt.Sprint(len(devices))},
+			{"states", fmt.Sprint(len(devices) - len(missing))},
+			{"missing", fmt.Sprint(len(missing))},
+			{"stale", fmt.Sprint(len(old))},
+			{"orphaned", fmt.Sprint(len(orphans))},
+		}},
+		{Title: "Models", Columns: []string{"model", "count"}, Rows: count(models)},
+		{Title: "Versions", Columns: []string{"model", "version", "count"}, Rows: dist},
+		{Title: "Missing States", Columns: []string{"name", "ip", "model"}, Rows: missing},
+		{Title: "Stale States", Columns: []string{"name", "model", "updated", "age"}, Rows: old},
+		{Title: "Serial Numbers", Columns: []string{"name", "model", "serial", "version"}, Rows: serials},
+		{Title: "Orphaned States", Columns: []string{"host", "model", "updated"}, Rows: orphans},
+	}
+
+	return &r
+}
+
+func (r *Report) Text(w io.Writer) error {
+	fmt.Fprintf(w, "Equipment report generated %s from %s\n", r.Generated.Format(time.RFC3339), r.Base)
+
+	tab := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, s := range r.Sections {
+		fmt.Fprintf(tab, "\n%s\n\n", s.Title)
+		if !(len(s.Rows) > 0) {
+			fmt.Fprintf(tab, "  none\n")
+			continue
+		}
+		fmt.Fprintf(tab, "  %s\n", strings.ToUpper(strings.Join(s.Columns, "\t")))
+		for _, row := range s.Rows {
+			fmt.Fprintf(tab, "  %s\n", strings.Join(row, "\t"))
+		}
+	}
+
+	return tab.Flush()
+}
+
+// CSV writes each section as a header row followed by its rows, all prefixed by the section title.
+func (r *Report) CSV(w io.Writer) error {
+	c := csv.NewWriter(w)
+	for _, s := range r.Sections {
+		if err := c.Write(append([]string{"section"}, s.Columns...)); err != nil {
+			return err
+		}
+		for _, row := range s.Rows {
+			if err := c.Write(append([]string{s.Title}, row...)); err != nil {
+				return err
+			}
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Equipment Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 0.8em; border-bottom: 1px solid #ddd; text-align: left; }
+th { background: #f0f0f0; }
+p.none { color: #888; }
+</style>
+</head>
+<body>
+<h1>Equipment Report</h1>
+<p>Generated {{.Generated.Format "2006-01-02T15:04:05Z07:00"}} from {{.Base}}</p>
+{{range .Sections}}
+<h2>{{.Title}}</h2>
+{{if .Rows}}<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>{{else}}<p class="none">none</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+func (r *Report) HTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
+
+func report(args []string) {
+
+	f := flag.NewFlagSet("report", flag.ExitOnError)
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Summarise the stored equipment states against the inventory\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s [options] report [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "General Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Equipment Report Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		f.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	var master string
+	f.StringVar(&master, "master", "rhubarb.geonet.org.nz.", "default master for equipment service lookup")
+
+	var lookup string
+	f.StringVar(&lookup, "zone", "wan.geonet.org.nz.", "default zone for equipment service lookup")
+
+	var models string
+	f.StringVar(&models, "models", ".*", "regex expression to match equipment models")
+
+	var sites string
+	f.StringVar(&sites, "sites", ".*", "regex expression to match equipment sites")
+
+	var uninstalled bool
+	f.BoolVar(&uninstalled, "uninstalled", false, "include equipment tagged as uninstalled")
+
+	var stale time.Duration
+	f.DurationVar(&stale, "stale", time.Hour*24*7, "flag states which haven't been updated within this time")
+
+	var format string
+	f.StringVar(&format, "format", "text", "report format (text, csv or html)")
+
+	var output string
+	f.StringVar(&output, "output", "-", "report output file")
+
+	if err := f.Parse(args); err != nil {
+		f.Usage()
+
+		log.Fatalf("Invalid option(s) given")
+	}
+
+	details, err := zone.LoadLocal(master, []string{lookup}, []string{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	skip := regexp.MustCompile("^Uninstalled")
+
+	var devices []*zone.Device
+	for _, l := range details.MustMatchByModel(models).MustMatchByName(sites).List {
+		if !uninstalled && skip.MatchString(l.Model) {
+			continue
+		}
+		devices = append(devices, l)
+	}
+
+	found, err := states(base)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := shared(base, found, kvstore); err != nil {
+		log.Fatal(err)
+	}
+
+	r := NewReport(devices, details.List, found, stale)
+
+	w := os.Stdout
+	if output != "" && output != "-" {
+		if w, err = os.Create(output); err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+	}
+	claimStdout(w)
+
+	switch format {
+	case "csv":
+		err = r.CSV(w)
+	case "html":
+		err = r.HTML(w)
+	case "text", "":
+		err = r.Text(w)
+	default:
+		log.Fatalf("Unknown report format: %s", format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
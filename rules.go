@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ozym/dmc"
+)
+
+// Condition is a simple "key op value" comparison, e.g. "voltage < 11.8".
+type Condition struct {
+	Key       string
+	Op        string
+	Threshold float64
+}
+
+func ParseCondition(s string) (*Condition, error) {
+	f := strings.Fields(s)
+	if len(f) != 3 {
+		return nil, fmt.Errorf("invalid condition, expected \"key op value\": %q", s)
+	}
+
+	switch f[1] {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return nil, fmt.Errorf("invalid condition operator %q: %q", f[1], s)
+	}
+
+	v, err := strconv.ParseFloat(f[2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition value %q: %q", f[2], s)
+	}
+
+	return &Condition{Key: f[0], Op: f[1], Threshold: v}, nil
+}
+
+func (c *Condition) String() string {
+	return fmt.Sprintf("%s %s %g", c.Key, c.Op, c.Threshold)
+}
+
+// Holds checks the condition, when active the threshold is relaxed by the hysteresis
+// amount so that an alert only clears once the value has moved clear of the limit.
+func (c *Condition) Holds(v float64, active bool, hysteresis float64) bool {
+	t := c.Threshold
+	if active {
+		switch c.Op {
+		case "<", "<=":
+			t += hysteresis
+		case ">", ">=":
+			t -= hysteresis
+		}
+	}
+
+	switch c.Op {
+	case "<":
+		return v < t
+	case "<=":
+		return v <= t
+	case ">":
+		return v > t
+	case ">=":
+		return v >= t
+	case "==":
+		return v == t
+	case "!=":
+		return v != t
+	}
+
+	return false
+}
+
+// Rule describes warning and critical thresholds for a state value.
+type Rule struct {
+	Name       string  `yaml:"name"`
+	Model      string  `yaml:"model"`
+	Site       string  `yaml:"site"`
+	Warning    string  `yaml:"warning"`
+	Critical   string  `yaml:"critical"`
+	Hysteresis float64 `yaml:"hysteresis"`
+
+	selector selector
+	warning  *Condition
+	critical *Condition
+}
+
+func (r *Rule) compile() error {
+	var err error
+
+	if r.selector, err = newSelector(r.Model, r.Site); err != nil {
+		return err
+	}
+	if r.Warning != "" {
+		if r.warning, err = ParseCondition(r.Warning); err != nil {
+			return err
+		}
+	}
+	if r.Critical != "" {
+		if r.critical, err = ParseCondition(r.Critical); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case r.warning == nil && r.critical == nil:
+		return fmt.Errorf("rule %q has no warning or critical condition", r.Name)
+	case r.Name != "":
+	case r.critical != nil:
+		r.Name = r.critical.Key
+	default:
+		r.Name = r.warning.Key
+	}
+
+	return nil
+}
+
+// Match checks whether the rule applies to the given model and site code, sites are not case sensitive.
+func (r *Rule) Match(model, site string) bool {
+	return r.selector.match(model, site)
+}
+
+// Check returns the alert level given the current level, and the value which was checked.
+func (r *Rule) Check(s *dmc.State, current Level) (Level, float64, bool) {
+	var value float64
+	var found bool
+
+	for _, x := range []struct {
+		level Level
+		cond  *Condition
+	}{{CriticalLevel, r.critical}, {WarningLevel, r.warning}} {
+		if x.cond == nil {
+			continue
+		}
+		v, ok := numeric(s.Values[x.cond.Key])
+		if !ok {
+			continue
+		}
+		if x.cond.Holds(v, !(current < x.level), r.Hysteresis) {
+			return x.level, v, true
+		}
+		value, found = v, true
+	}
+
+	return InfoLevel, value, found
+}
+
+// id identifies the rule in the stored alert levels, rules with the same name can apply to different models or sites
+func (r *Rule) id() string {
+	return r.Name + "/" + r.Model + "/" + r.Site
+}
+
+func (r *Rule) condition(level Level) *Condition {
+	switch level {
+	case CriticalLevel:
+		return r.critical
+	case WarningLevel:
+		return r.warning
+	default:
+		return nil
+	}
+}
+
+// Rules is a set of thresholds loaded from a rules file.
+type Rules struct {
+	Rules []*Rule `yaml:"rules"`
+
+	mu sync.Mutex
+}
+
+// the active alert thresholds, these may be replaced by the daemon while devices are being polled
+var thresholds struct {
+	sync.RWMutex
+	rules *Rules
+}
+
+func currentThresholds() *Rules {
+	thresholds.RLock()
+	defer thresholds.RUnlock()
+
+	return thresholds.rules
+}
+
+func setThresholds(r *Rules) {
+	thresholds.Lock()
+	defer thresholds.Unlock()
+
+	thresholds.rules = r
+}
+
+func LoadRules(path string) (*Rules, error) {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var r Rules
+	if err := yaml.Unmarshal(c, &r); err != nil {
+		return nil, err
+	}
+
+	for _, x := range r.Rules {
+		if err := x.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &r, nil
+}
+
+// convert a state value into a number, string values use their leading field (e.g. "12.5 V")
+func numeric(v interface{}) (float64, bool) {
+	if x, ok := number(v); ok {
+		return x, true
+	}
+	if n, ok := v.(string); ok {
+		if f := strings.Fields(n); len(f) > 0 {
+			if x, err := strconv.ParseFloat(f[0], 64); err == nil {
+				return x, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// the alert levels of each rule are kept between runs to allow for hysteresis
+func alertFile(d dmc.Device) string {
+	_, f := location(d)
+	if f == "" {
+		return ""
+	}
+	return strings.TrimSuffix(f, ".json") + ".alerts"
+}
+
+func loadAlerts(f string) (map[string]Level, error) {
+	alerts := make(map[string]Level)
+
+	c, err := ioutil.ReadFile(f)
+	switch {
+	case os.IsNotExist(err):
+		return alerts, nil
+	case err != nil:
+		return nil, err
+	}
+
+	var levels map[string]string
+	if err := json.Unmarshal(c, &levels); err != nil {
+		return nil, err
+	}
+	for k, v := range levels {
+		alerts[k] = ParseLevel(v)
+	}
+
+	return alerts, nil
+}
+
+func saveAlerts(f string, alerts map[string]Level) error {
+	levels := make(map[string]string)
+	for k, v := range alerts {
+		if v > InfoLevel {
+			levels[k] = v.String()
+		}
+	}
+
+	if !(len(levels) > 0) {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	b, err := json.MarshalIndent(levels, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(f, append(b, '\n'), 0644)
+}
+
+// alert is a notification to be sent for a rule which has changed level
+type alert struct {
+	level   Level
+	subject string
+	body    string
+}
+
+// Evaluate checks the device state against the matching rules, alerts are raised
+// whenever a rule changes level. A matching site rule overrides any model only rules with
+// the same name, recoveries are sent at the level which has been cleared.
+func (r *Rules) Evaluate(d dmc.Device, s *dmc.State) error {
+	model, _ := s.Values["model"].(string)
+	if model == "" {
+		model = d.Model
+	}
+
+	f := alertFile(d)
+	if f == "" {
+		return nil
+	}
+
+	// the notifiers are only called once the alert levels have been stored and unlocked
+	pending, err := r.update(d, s, model, f)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range pending {
+		if err := currentNotifiers().Notify(a.level, a.subject, a.body); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// update stores the new alert levels of the device and returns any alerts which need to be sent
+func (r *Rules) update(d dmc.Device, s *dmc.State, model, f string) ([]alert, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last, err := loadAlerts(f)
+	if err != nil {
+		return nil, err
+	}
+
+	_, code := names(d)
+
+	var matched []*Rule
+
+	sited := make(map[string]bool)
+	for _, x := range r.Rules {
+		if !x.Match(model, code) {
+			continue
+		}
+		if x.Site != "" {
+			sited[x.Name] = true
+		}
+		matched = append(matched, x)
+	}
+
+	var pending []alert
+
+	// only the levels of rules still in use are kept
+	alerts := make(map[string]Level)
+	for _, x := range matched {
+		if x.Site == "" && sited[x.Name] {
+			continue
+		}
+
+		current := last[x.id()]
+		alerts[x.id()] = current
+
+		level, v, ok := x.Check(s, current)
+		if !ok || level == current {
+			continue
+		}
+		alerts[x.id()] = level
+
+		subject := fmt.Sprintf("%s [%s] %s %s", d.Name, d.IP.String(), x.Name, level.String())
+
+		notify, msg := level, ""
+		switch c := x.condition(level); {
+		case c != nil:
+			msg = fmt.Sprintf("%s = %g (%s: %s)", c.Key, v, level.String(), c.String())
+		default:
+			subject = fmt.Sprintf("%s [%s] %s recovered", d.Name, d.IP.String(), x.Name)
+			msg = fmt.Sprintf("%s = %g (was %s)", x.Name, v, current.String())
+			notify = current
+		}
+
+		pending = append(pending, alert{level: notify, subject: subject, body: msg})
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		return nil, err
+	}
+
+	if err := saveAlerts(f, alerts); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
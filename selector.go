@@ -0,0 +1,41 @@
+package main
+
+import (
+	"regexp"
+)
+
+// selector matches devices against optional model and site code expressions, site expressions
+// must match the whole code and are not case sensitive.
+type selector struct {
+	model *regexp.Regexp
+	site  *regexp.Regexp
+}
+
+func newSelector(model, site string) (selector, error) {
+	var s selector
+	var err error
+
+	if model != "" {
+		if s.model, err = regexp.Compile(model); err != nil {
+			return s, err
+		}
+	}
+	if site != "" {
+		if s.site, err = regexp.Compile("^(?i:" + site + ")$"); err != nil {
+			return s, err
+		}
+	}
+
+	return s, nil
+}
+
+// match checks the model and site code, blank expressions match anything.
+func (s selector) match(model, site string) bool {
+	if s.model != nil && !s.model.MatchString(model) {
+		return false
+	}
+	if s.site != nil && !s.site.MatchString(site) {
+		return false
+	}
+	return true
+}
@@ -1,16 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"regexp"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/paulstuart/ping"
-
 	"github.com/ozym/dmc"
 	"github.com/ozym/zone"
 )
@@ -51,21 +51,69 @@ func status(args []string) {
 	var limit int
 	f.IntVar(&limit, "limit", 8, "number of concurrent queries")
 
+	var format string
+	f.StringVar(&format, "format", "", "optional result output format on stdout (jsonl, csv or table)")
+
+	var values string
+	f.StringVar(&values, "values", strings.Join(resultKeys, ","), "comma separated state values included in the result output")
+
+	var deadline time.Duration
+	f.DurationVar(&deadline, "deadline", 0, "optional time limit for the whole run, devices not yet checked are skipped")
+
 	var models string
 	f.StringVar(&models, "models", ".*", "regex expression to match equipment models")
 
 	var sites string
 	f.StringVar(&sites, "sites", ".*", "regex expression to match equipment sites")
 
+	var rules string
+	f.StringVar(&rules, "rules", "", "yaml file of state of health alert thresholds")
+
+	var consul string
+	f.StringVar(&consul, "consul", "", "optional consul server to push device health checks into")
+
+	var services string
+	f.StringVar(&services, "consul-services", "", "yaml file of consul service mappings, as used by load")
+
+	var stale time.Duration
+	f.DurationVar(&stale, "consul-stale", time.Hour*24, "age at which consul checks not updated by any run are marked as stale, zero to disable")
+
 	if err := f.Parse(args); err != nil {
 		f.Usage()
 
 		log.Fatalf("Invalid option(s) given")
 	}
 
+	if rules != "" {
+		r, err := LoadRules(rules)
+		if err != nil {
+			log.Fatal(err)
+		}
+		setThresholds(r)
+	}
+
+	var checks *Checks
+	if consul != "" {
+		settings, err := checkSettings(services)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if checks, err = NewChecks(consul, settings); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	m := regexp.MustCompile(models)
 	s := regexp.MustCompile(sites)
 
+	out, err := NewOutput(os.Stdout, format, strings.Split(values, ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := interrupt(deadline)
+	defer cancel()
+
 	// concurrent goroutines
 	var wg sync.WaitGroup
 
@@ -94,48 +142,118 @@ func status(args []string) {
 		}
 
 		// wait ...
-		sem <- struct{}{}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
 		wg.Add(1)
 
 		go func(d dmc.Device) {
 			defer func() { <-sem; wg.Done() }()
 
-			if ok := ping.Ping(d.IP.String(), (int)(timeout/time.Second)); !ok {
-				if verbose {
-					log.Printf("skipping: %s\n", d.String())
-				}
-				return
+			r, st := inspect(ctx, d, timeout, retries)
+			if err := out.Write(NewResult(d, r.Reachable(), ctx.Err() != nil, st, out.Keys())); err != nil {
+				log.Println(err)
 			}
-			if verbose {
-				log.Printf("discover!: %s\n", d.String())
+			// an interrupted poll says nothing about the device
+			if ctx.Err() == nil {
+				if err := checks.Update(d, r.Reachable(), st); err != nil {
+					log.Println(err)
+				}
 			}
+		}(d)
+	}
 
-			for _, m := range dmc.ModelList {
-				if !d.Match(m) {
-					continue
-				}
+	wg.Wait()
 
-				if verbose {
-					log.Printf("checking: %s against %s\n", d.String(), m.Name())
-				}
+	if err := ctx.Err(); err != nil {
+		log.Printf("run stopped early: %s\n", err)
+	} else if _, err := checks.Expire(stale, time.Now()); err != nil {
+		log.Println(err)
+	}
 
-				if s, _ := d.Identify(m, d.Model, timeout, retries); s != nil {
-					if device(d, s) {
-						return
-					}
-				}
-				if s := d.Discover(m, d.Model, timeout, retries); s != nil {
-					if device(d, s) {
-						return
-					}
-				}
+	if err := out.Close(); err != nil {
+		log.Println(err)
+	}
 
-				if verbose {
-					log.Printf("missed equipment: %s\n", d.String())
-				}
+	if err := flushChanges(); err != nil {
+		log.Println(err)
+	}
+}
+
+// inspect checks whether a device is reachable and then tries to identify it against
+// each matching model, it returns the probe results and the accepted state if any.
+func inspect(ctx context.Context, d dmc.Device, timeout time.Duration, retries int) (*Reachability, *dmc.State) {
+
+	register(d)
+
+	if ctx.Err() != nil {
+		return &Reachability{}, nil
+	}
+
+	r := reachable(ctx, d, timeout)
+	if !r.Reachable() {
+		if verbose {
+			log.Printf("skipping: %s\n", d.String())
+		}
+		return r, nil
+	}
+	if verbose {
+		log.Printf("discover!: %s\n", d.String())
+	}
+
+	for _, m := range dmc.ModelList {
+		if ctx.Err() != nil {
+			break
+		}
+		if !d.Match(m) {
+			continue
+		}
+
+		if verbose {
+			log.Printf("checking: %s against %s\n", d.String(), m.Name())
+		}
+
+		if s, _ := d.IdentifyContext(ctx, m, d.Model, timeout, retries); s != nil {
+			health(ctx, d, m, s, timeout, retries)
+			r.Values(s)
+			if device(d, s) {
+				return r, s
 			}
-		}(d)
+		}
+		if s := d.DiscoverContext(ctx, m, d.Model, timeout, retries); s != nil {
+			r.Values(s)
+			if device(d, s) {
+				return r, s
+			}
+		}
+
+		if verbose {
+			log.Printf("missed equipment: %s\n", d.String())
+		}
 	}
 
-	wg.Wait()
+	return r, nil
+}
+
+// health adds any operational state of health values reported by the model to the identified state
+func health(ctx context.Context, d dmc.Device, m dmc.Model, s *dmc.State, timeout time.Duration, retries int) {
+
+	h, err := d.StatusContext(ctx, m, d.Model, timeout, retries)
+	if err != nil && verbose {
+		log.Printf("status: %s against %s: %s\n", d.String(), m.Name(), err)
+	}
+	if h == nil {
+		return
+	}
+
+	for k, v := range h.Values {
+		if _, ok := s.Values[k]; ok && k != "timestamp" {
+			continue
+		}
+		s.Values[k] = v
+	}
 }
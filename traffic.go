@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ozym/dmc"
+)
+
+// interfaces matching this expression are added to the monthly usage totals
+var usageInterfaces = regexp.MustCompile(".*")
+
+// software loopback interfaces never count towards usage
+const loopbackType = 24
+
+// interface counters which have rates added, octets are given as bits per second
+// and the error and discard counts (always from the 32 bit ifTable) as per second.
+var rateCounters = []struct {
+	key    string
+	scale  float64
+	octets bool
+}{
+	{"rx", 8.0, true},
+	{"tx", 8.0, true},
+	{"rx_errors", 1.0, false},
+	{"tx_errors", 1.0, false},
+	{"rx_discards", 1.0, false},
+	{"tx_discards", 1.0, false},
+}
+
+// Usage holds the cumulative traffic of a device for a calendar month (UTC), the
+// final totals of the month before are kept once a new month has started.
+type Usage struct {
+	Month      string               `json:"month"`
+	RX         float64              `json:"rx"`
+	TX         float64              `json:"tx"`
+	Interfaces map[string][]float64 `json:"interfaces"`
+	Previous   *Usage               `json:"previous,omitempty"`
+}
+
+// add includes a fraction of the interface traffic into the usage totals
+func (u *Usage) add(moved map[string][2]float64, fraction float64) {
+	for n, m := range moved {
+		v := u.Interfaces[n]
+		if len(v) != 2 {
+			v = []float64{0, 0}
+		}
+		v[0], v[1] = v[0]+m[0]*fraction, v[1]+m[1]*fraction
+		u.Interfaces[n] = v
+
+		u.RX, u.TX = u.RX+m[0]*fraction, u.TX+m[1]*fraction
+	}
+}
+
+func usageFile(d dmc.Device) string {
+	_, f := location(d)
+	if f == "" {
+		return ""
+	}
+	return strings.TrimSuffix(f, ".json") + ".usage"
+}
+
+func loadUsage(f string) (*Usage, error) {
+	u := Usage{Interfaces: make(map[string][]float64)}
+
+	c, err := ioutil.ReadFile(f)
+	switch {
+	case os.IsNotExist(err):
+		return &u, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err := json.Unmarshal(c, &u); err != nil {
+		return nil, err
+	}
+	if u.Interfaces == nil {
+		u.Interfaces = make(map[string][]float64)
+	}
+
+	return &u, nil
+}
+
+func saveUsage(f string, u *Usage) error {
+	b, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(f, append(b, '\n'), 0644)
+}
+
+// interface counters keyed by name
+func counters(s *dmc.State) map[string]map[string]interface{} {
+	res := make(map[string]map[string]interface{})
+
+	list, ok := s.Values["interfaces"].([]interface{})
+	if !ok {
+		return res
+	}
+
+	for _, x := range list {
+		m, ok := x.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		n, ok := m["name"].(string)
+		if !ok || n == "" {
+			continue
+		}
+		res[n] = m
+	}
+
+	return res
+}
+
+// delta between two counter readings, allowing for 32 bit wrap or a counter reset
+func delta(old, now float64, bits float64, reset bool) float64 {
+	switch {
+	case reset:
+		return now
+	case !(now < old):
+		return now - old
+	case bits == 32:
+		return now + math.Pow(2, 32) - old
+	default:
+		// a 64 bit counter going backwards has been reset
+		return now
+	}
+}
+
+// traffic adds interface rates, computed against the previously stored state,
+// and the cumulative monthly usage of the device.
+func traffic(d dmc.Device, s *dmc.State) error {
+
+	now := counters(s)
+	if !(len(now) > 0) {
+		return nil
+	}
+
+	p, err := previous(d)
+	if p == nil || err != nil {
+		return err
+	}
+	old := counters(p)
+
+	t1, ok1 := numeric(s.Values["timestamp"])
+	t0, ok0 := numeric(p.Values["timestamp"])
+	if !ok1 || !ok0 || !(t1 > t0) {
+		return nil
+	}
+	elapsed := t1 - t0
+
+	// the device has rebooted since the last check
+	var reset bool
+	if up, ok := numeric(s.Values["uptime"]); ok && up < elapsed {
+		reset = true
+	}
+
+	f := usageFile(d)
+	if f == "" {
+		return nil
+	}
+
+	u, err := loadUsage(f)
+	if err != nil {
+		return err
+	}
+
+	moved := make(map[string][2]float64)
+	for n, m := range now {
+		o, ok := old[n]
+		if !ok {
+			continue
+		}
+
+		bits, _ := numeric(m["bits"])
+
+		var octets [2]float64
+		for i, c := range rateCounters {
+			a, okA := numeric(o[c.key])
+			b, okB := numeric(m[c.key])
+			if !okA || !okB {
+				continue
+			}
+			size := float64(32)
+			if c.octets {
+				size = bits
+			}
+			v := delta(a, b, size, reset)
+			if c.octets {
+				octets[i] = v
+			}
+
+			// errors and discards are usually low, so keep some fraction of the rate
+			switch rate := v * c.scale / elapsed; {
+			case c.octets:
+				m[c.key+"_rate"] = math.Floor(rate + 0.5)
+			default:
+				m[c.key+"_rate"] = math.Floor(rate*1000.0+0.5) / 1000.0
+			}
+		}
+
+		if t, _ := numeric(m["type"]); int(t) == loopbackType || !usageInterfaces.MatchString(n) {
+			continue
+		}
+
+		moved[n] = octets
+	}
+
+	// an interval crossing the start of the month is split between the two months by time
+	end := time.Unix(int64(t1), 0).UTC()
+	start := time.Date(end.Year(), end.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	share := 1.0
+	if b := float64(start.Unix()); b > t0 {
+		share = (t1 - b) / elapsed
+	}
+
+	month := end.Format("2006-01")
+	if u.Month != month {
+		if u.Month == time.Unix(int64(t0), 0).UTC().Format("2006-01") {
+			u.add(moved, 1.0-share)
+		}
+
+		var closed *Usage
+		if u.Month != "" {
+			closed, u.Previous = u, nil
+		}
+		u = &Usage{Month: month, Interfaces: make(map[string][]float64), Previous: closed}
+	}
+	u.add(moved, share)
+
+	for n := range moved {
+		if v := u.Interfaces[n]; len(v) == 2 {
+			now[n]["usage_rx"], now[n]["usage_tx"] = v[0], v[1]
+		}
+	}
+
+	s.Values["usage_rx"] = u.RX
+	s.Values["usage_tx"] = u.TX
+	s.Values["usage"] = u.RX + u.TX
+
+	if verbose {
+		log.Printf("%s usage for %s: %.0f bytes\n", d.Name, month, u.RX+u.TX)
+	}
+
+	return saveUsage(f, u)
+}